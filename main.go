@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
 	"os/signal"
@@ -13,6 +14,18 @@ import (
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		rotateKey(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "experiment-report" {
+		if err := kira.ExperimentReport(os.Stdout); err != nil {
+			log.Fatal("Experiment report failed:", err)
+		}
+		return
+	}
+
 	// Initialize Kira bot
 	bot, err := kira.NewKiraBot(settings.Settings.TelegramToken, settings.Settings.LlmKey)
 	if err != nil {
@@ -84,3 +97,26 @@ func main() {
 		}
 	}
 }
+
+// rotateKey re-encrypts all stored chats with a new master key.
+// Stop the bot first and update ENCRYPTIONKEY / ENCRYPTIONKEYFILE afterwards.
+func rotateKey(args []string) {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	newKeyFile := fs.String("new-key-file", "", "file containing the new base64 encoded 32 byte key")
+	fs.Parse(args)
+
+	if *newKeyFile == "" {
+		log.Fatal("Usage: kira rotate-key -new-key-file <file>")
+	}
+
+	newKey, err := kira.LoadMasterKeyFile(*newKeyFile)
+	if err != nil {
+		log.Fatal("Failed to load new key:", err)
+	}
+
+	if err := kira.RotateEncryptionKey(newKey); err != nil {
+		log.Fatal("Key rotation failed:", err)
+	}
+
+	log.Println("Key rotation complete. Point ENCRYPTIONKEY or ENCRYPTIONKEYFILE to the new key before starting the bot.")
+}
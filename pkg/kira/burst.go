@@ -0,0 +1,105 @@
+package kira
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// maxBurstWait caps the configured wait, after 5 minutes a message isn't
+// fresh anymore, see shouldRespondToMessage
+const maxBurstWait = 5 * time.Minute
+
+// burstTracker coalesces messages the user sends in a row. The reply waits
+// until the user was quiet for a while, and a reply that is still being
+// typed stops when the user writes again, so the next run answers the whole
+// burst.
+type burstTracker struct {
+	quiet   time.Duration // time without a new user message before answering
+	maxWait time.Duration // a burst is answered at the latest this long after its first message
+
+	mu     sync.Mutex
+	typing map[int64]chan struct{} // closed when the user writes while a reply is typed
+}
+
+func newBurstTracker(quiet, maxWait time.Duration) *burstTracker {
+	if maxWait > maxBurstWait {
+		log.Printf("Burst wait %v is capped to %v", maxWait, maxBurstWait)
+		maxWait = maxBurstWait
+	}
+	if quiet > maxWait {
+		log.Printf("Burst quiet period %v is capped to the wait of %v", quiet, maxWait)
+		quiet = maxWait
+	}
+	return &burstTracker{quiet: quiet, maxWait: maxWait, typing: make(map[int64]chan struct{})}
+}
+
+// waiting reports whether the user may still be writing: the last message is
+// younger than the quiet period and the burst hasn't waited for maxWait yet.
+// The burst are the user messages after the last bot message.
+func (b *burstTracker) waiting(lastMessages []ChatMessage, now time.Time) bool {
+	if b.quiet <= 0 || len(lastMessages) == 0 {
+		return false
+	}
+	last := lastMessages[len(lastMessages)-1]
+	if last.IsBot || now.Sub(time.Unix(last.Timestamp, 0)) >= b.quiet {
+		return false
+	}
+
+	start := last.Timestamp
+	for i := len(lastMessages) - 1; i >= 0 && !lastMessages[i].IsBot; i-- {
+		start = lastMessages[i].Timestamp
+	}
+	return now.Sub(time.Unix(start, 0)) < b.maxWait
+}
+
+// beginReply marks that a reply for the chat is being written
+func (b *burstTracker) beginReply(chatID int64) {
+	b.mu.Lock()
+	b.typing[chatID] = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// endReply is called when the reply is sent or given up
+func (b *burstTracker) endReply(chatID int64) {
+	b.mu.Lock()
+	delete(b.typing, chatID)
+	b.mu.Unlock()
+}
+
+// userWrote interrupts the reply being written in the chat
+func (b *burstTracker) userWrote(chatID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, ok := b.typing[chatID]
+	if !ok {
+		return
+	}
+	select {
+	case <-ch:
+	default:
+		log.Printf("User of chat %d wrote while the reply was typed, stopping it", chatID)
+		close(ch)
+	}
+}
+
+// sleep waits d while a message is typed. It returns false right away when
+// the user writes in the meantime, the rest of the reply isn't sent then.
+func (b *burstTracker) sleep(chatID int64, d time.Duration) bool {
+	b.mu.Lock()
+	ch, ok := b.typing[chatID]
+	b.mu.Unlock()
+	if !ok {
+		time.Sleep(d)
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
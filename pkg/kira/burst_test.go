@@ -0,0 +1,47 @@
+package kira
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBurstTrackerWaiting(t *testing.T) {
+	now := time.Date(2026, 5, 17, 12, 0, 0, 0, time.UTC)
+	user := func(ago time.Duration) ChatMessage {
+		return ChatMessage{Timestamp: now.Add(-ago).Unix()}
+	}
+	bot := func(ago time.Duration) ChatMessage {
+		return ChatMessage{Timestamp: now.Add(-ago).Unix(), IsBot: true}
+	}
+
+	tests := []struct {
+		name     string
+		quiet    time.Duration
+		messages []ChatMessage
+		want     bool
+	}{
+		{"no messages", 15 * time.Second, nil, false},
+		{"fresh message", 15 * time.Second, []ChatMessage{bot(time.Hour), user(5 * time.Second)}, true},
+		{"quiet long enough", 15 * time.Second, []ChatMessage{bot(time.Hour), user(15 * time.Second)}, false},
+		{"last message from the bot", 15 * time.Second, []ChatMessage{user(10 * time.Second), bot(5 * time.Second)}, false},
+		{"burst below max wait", 15 * time.Second, []ChatMessage{bot(time.Hour), user(50 * time.Second), user(30 * time.Second), user(5 * time.Second)}, true},
+		{"burst at max wait", 15 * time.Second, []ChatMessage{bot(time.Hour), user(60 * time.Second), user(30 * time.Second), user(5 * time.Second)}, false},
+		{"burst starts after the bot", 15 * time.Second, []ChatMessage{user(2 * time.Minute), bot(50 * time.Second), user(5 * time.Second)}, true},
+		{"disabled", 0, []ChatMessage{bot(time.Hour), user(time.Second)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBurstTracker(tt.quiet, time.Minute)
+			if got := b.waiting(tt.messages, now); got != tt.want {
+				t.Errorf("waiting = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBurstTrackerCaps(t *testing.T) {
+	b := newBurstTracker(time.Hour, time.Hour)
+	if b.maxWait != maxBurstWait || b.quiet != maxBurstWait {
+		t.Errorf("quiet %v and max wait %v, want both capped to %v", b.quiet, b.maxWait, maxBurstWait)
+	}
+}
@@ -0,0 +1,186 @@
+package kira
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	promptCacheTTL    = time.Hour
+	promptCacheMargin = time.Minute // a cache is recreated this long before it expires
+)
+
+// promptCacheKey identifies the static prompt of a chat and call type.
+// ChatID 0 is used for prompts that are the same for every chat.
+type promptCacheKey struct {
+	chatID  int64
+	purpose string
+}
+
+// promptCache keeps the static part of a prompt, the system instruction and
+// the memory form, on the provider side, so it isn't sent and billed in full
+// on every call.
+type promptCache interface {
+	// model returns a model for the static prompt. If cached is false the
+	// caller has to send system and static with the request itself.
+	model(ctx context.Context, key promptCacheKey, modelName, system, static string) (model *genai.GenerativeModel, cached bool)
+	// invalidate drops the caches of a chat, e.g. after the memory form changed
+	invalidate(chatID int64)
+	close()
+}
+
+// inlinePromptCache is the local fallback, it sends the whole prompt every time.
+// It is used if caching is switched off or the provider can't cache.
+type inlinePromptCache struct {
+	llm *llmRegistry
+}
+
+func (c inlinePromptCache) model(ctx context.Context, key promptCacheKey, modelName, system, static string) (*genai.GenerativeModel, bool) {
+	model := c.llm.model(modelName)
+	model.SystemInstruction = &genai.Content{Parts: []genai.Part{genai.Text(system)}}
+	return model, false
+}
+
+func (c inlinePromptCache) invalidate(chatID int64) {}
+
+func (c inlinePromptCache) close() {}
+
+type promptCacheEntry struct {
+	name    string // cachedContents/..., empty if creating the cache failed
+	model   string
+	hash    [sha256.Size]byte
+	expires time.Time
+}
+
+// geminiPromptCache uses Gemini's cached content API. If a cache can't be
+// created, e.g. because the prompt is below the minimum size for caching, the
+// prompt is sent inline until the cache would have expired. While the
+// provider is down the prompt is sent inline and the cache is created later.
+type geminiPromptCache struct {
+	llm     *llmRegistry
+	inline  inlinePromptCache
+	retry   func(ctx context.Context, call func() (bool, error)) error // the circuit breaker and retry policy of the bot
+	mu      sync.Mutex
+	entries map[promptCacheKey]promptCacheEntry
+}
+
+func newGeminiPromptCache(llm *llmRegistry, retry func(ctx context.Context, call func() (bool, error)) error) *geminiPromptCache {
+	return &geminiPromptCache{
+		llm:     llm,
+		inline:  inlinePromptCache{llm: llm},
+		retry:   retry,
+		entries: make(map[promptCacheKey]promptCacheEntry),
+	}
+}
+
+func (c *geminiPromptCache) model(ctx context.Context, key promptCacheKey, modelName, system, static string) (*genai.GenerativeModel, bool) {
+	hash := sha256.Sum256([]byte(modelName + "\x00" + system + "\x00" + static))
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, exists := c.entries[key]
+	c.mu.Unlock()
+
+	if exists && entry.hash == hash && now.Before(entry.expires.Add(-promptCacheMargin)) {
+		if entry.name == "" {
+			return c.inline.model(ctx, key, modelName, system, static)
+		}
+		return c.llm.modelFromCache(entry.name, entry.model), true
+	}
+	if exists && entry.name != "" {
+		// The form changed or the cache is about to expire
+		c.deleteRemote(entry.name)
+	}
+
+	content := &genai.CachedContent{
+		Model:             modelName,
+		SystemInstruction: &genai.Content{Parts: []genai.Part{genai.Text(system)}},
+		Expiration:        genai.ExpireTimeOrTTL{TTL: promptCacheTTL},
+	}
+	if static != "" {
+		content.Contents = []*genai.Content{{Role: "user", Parts: []genai.Part{genai.Text(static)}}}
+	}
+	var cc *genai.CachedContent
+	err := c.retry(ctx, func() (bool, error) {
+		var err error
+		cc, err = c.llm.client.CreateCachedContent(ctx, content)
+		return false, err
+	})
+
+	if isOutage(err) || errors.Is(err, ErrCircuitOpen) {
+		// Not the prompt's fault, try again on the next call
+		log.Printf("Prompt cache for chat %d (%s) not created, provider is down: %v", key.chatID, key.purpose, err)
+		c.mu.Lock()
+		delete(c.entries, key)
+		c.mu.Unlock()
+		return c.inline.model(ctx, key, modelName, system, static)
+	}
+
+	entry = promptCacheEntry{hash: hash, expires: now.Add(promptCacheTTL)}
+	if err != nil {
+		log.Printf("Prompt cache for chat %d (%s) not created, sending inline: %v", key.chatID, key.purpose, err)
+	} else {
+		entry.name = cc.Name
+		entry.model = cc.Model
+		if !cc.Expiration.ExpireTime.IsZero() {
+			entry.expires = cc.Expiration.ExpireTime
+		}
+		log.Printf("Created prompt cache %s for chat %d (%s)", cc.Name, key.chatID, key.purpose)
+	}
+
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	if entry.name == "" {
+		return c.inline.model(ctx, key, modelName, system, static)
+	}
+	return c.llm.modelFromCache(entry.name, entry.model), true
+}
+
+func (c *geminiPromptCache) invalidate(chatID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if key.chatID != chatID {
+			continue
+		}
+		if entry.name != "" {
+			c.deleteRemote(entry.name)
+		}
+		delete(c.entries, key)
+	}
+}
+
+// close deletes all caches, they would only cost storage until they expire
+func (c *geminiPromptCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.name != "" {
+			c.deleteCache(entry.name)
+		}
+		delete(c.entries, key)
+	}
+}
+
+// deleteRemote deletes a cache in the background
+func (c *geminiPromptCache) deleteRemote(name string) {
+	go c.deleteCache(name)
+}
+
+func (c *geminiPromptCache) deleteCache(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.llm.client.DeleteCachedContent(ctx, name); err != nil {
+		log.Printf("Error deleting prompt cache %s: %v", name, err)
+	}
+}
@@ -0,0 +1,53 @@
+package kira
+
+import (
+	"context"
+	"testing"
+)
+
+// TestGeminiPromptCacheOutage checks that a cache isn't created while the
+// provider is down and that it is tried again on the next call
+func TestGeminiPromptCacheOutage(t *testing.T) {
+	llm, err := newLLMRegistry("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer llm.Close()
+	key := promptCacheKey{chatID: 42, purpose: "talk"}
+
+	t.Run("breaker open", func(t *testing.T) {
+		k := &KiraBot{breaker: newCircuitBreaker("test")}
+		for i := 0; i < k.breaker.threshold; i++ {
+			k.breaker.failure()
+		}
+		c := newGeminiPromptCache(llm, k.callWithRetry)
+
+		// The breaker answers before the client is called
+		if _, cached := c.model(context.Background(), key, talkModelName, "system", "form"); cached {
+			t.Error("cached while the breaker is open")
+		}
+		if _, ok := c.entries[key]; ok {
+			t.Error("entry stored while the breaker is open")
+		}
+	})
+
+	t.Run("provider down", func(t *testing.T) {
+		calls := 0
+		c := newGeminiPromptCache(llm, func(ctx context.Context, call func() (bool, error)) error {
+			calls++
+			return ErrUnavailable
+		})
+
+		for i := 0; i < 2; i++ {
+			if _, cached := c.model(context.Background(), key, talkModelName, "system", "form"); cached {
+				t.Error("cached while the provider is down")
+			}
+		}
+		if calls != 2 {
+			t.Errorf("%d create attempts, want 2", calls)
+		}
+		if _, ok := c.entries[key]; ok {
+			t.Error("entry stored while the provider is down")
+		}
+	})
+}
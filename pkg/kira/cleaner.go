@@ -21,30 +21,13 @@ type SimpleSanitizer struct {
 	phraseRegexes []*regexp.Regexp
 }
 
-// NewSimpleSanitizer creates a new sanitizer with predefined bad words and phrases
+// NewSimpleSanitizer creates an empty sanitizer, add words with AddFilterItems.
+// The wordlists live in moderation/, see moderation.go.
 func NewSimpleSanitizer() *SimpleSanitizer {
-	sanitizer := &SimpleSanitizer{
+	return &SimpleSanitizer{
 		filterItems:   make([]FilterItem, 0),
 		phraseRegexes: make([]*regexp.Regexp, 0),
 	}
-
-	// Add default bad words and phrases
-	defaultItems := []string{
-		// Single words
-		"fotze",
-		"hure",
-		"fick",
-		"arschfotze",
-		"pussy",
-
-		// Multi-word phrases
-		"tank man",
-
-		// Add more as needed
-	}
-
-	sanitizer.AddFilterItems(defaultItems)
-	return sanitizer
 }
 
 // normalize converts text to lowercase and normalizes whitespace
@@ -78,7 +61,8 @@ func (s *SimpleSanitizer) createPhraseRegex(phrase string) *regexp.Regexp {
 	// Escape special regex characters and replace spaces with flexible whitespace/punctuation pattern
 	escaped := regexp.QuoteMeta(phrase)
 	// Allow any amount of whitespace or punctuation between words
-	pattern := strings.ReplaceAll(escaped, `\ `, `[\s\p{P}]+`)
+	// (QuoteMeta leaves spaces unescaped)
+	pattern := strings.ReplaceAll(escaped, " ", `[\s\p{P}]+`)
 	// Add word boundaries
 	pattern = `\b` + pattern + `\b`
 
@@ -248,16 +232,16 @@ func (s *SimpleSanitizer) CleanStringSlice(items []string) []string {
 	return cleaned
 }
 
-// CleanPersonenImLeben cleans PersonImLeben entries
-func (s *SimpleSanitizer) CleanPersonenImLeben(persons []PersonImLeben) []PersonImLeben {
-	cleaned := make([]PersonImLeben, 0)
+// CleanPersonenImLeben cleans Person entries
+func (s *SimpleSanitizer) CleanPersonenImLeben(persons []Person) []Person {
+	cleaned := make([]Person, 0)
 
 	for _, person := range persons {
-		// Check all text fields in PersonImLeben
+		// Check all text fields in Person
 		if s.containsBadContent(person.Name) ||
-			s.containsBadContent(person.Alter) ||
-			s.containsBadContent(person.BeziehungZumUser) ||
-			s.containsBadContent(person.GeschichteMitUser) {
+			s.containsBadContent(person.Age) ||
+			s.containsBadContent(person.RelationToUser) ||
+			s.containsBadContent(person.HistoryWithUser) {
 			// Skip this entire person entry if any field contains filtered content
 			log.Printf("Removed person entry '%s' due to inappropriate content", person.Name)
 			continue
@@ -273,34 +257,34 @@ func (s *SimpleSanitizer) CleanCharacter(char Character) Character {
 	cleaned := char
 
 	// Check and clean simple string fields
-	if s.containsBadContent(char.EchterName) {
-		cleaned.EchterName = ""
+	if s.containsBadContent(char.Name) {
+		cleaned.Name = ""
 	}
-	if s.containsBadContent(char.Beruf) {
-		cleaned.Beruf = ""
+	if s.containsBadContent(char.Job) {
+		cleaned.Job = ""
 	}
-	if s.containsBadContent(char.Wohnort) {
-		cleaned.Wohnort = ""
+	if s.containsBadContent(char.Location) {
+		cleaned.Location = ""
 	}
-	if s.containsBadContent(char.Beziehungsstatus) {
-		cleaned.Beziehungsstatus = ""
+	if s.containsBadContent(char.RelationshipStatus) {
+		cleaned.RelationshipStatus = ""
 	}
-	if s.containsBadContent(char.Lieblingsfarbe) {
-		cleaned.Lieblingsfarbe = ""
+	if s.containsBadContent(char.FavoriteColor) {
+		cleaned.FavoriteColor = ""
 	}
 	if s.containsBadContent(char.FlirtLevel) {
 		cleaned.FlirtLevel = ""
 	}
 
 	// Clean slice fields
-	cleaned.Interessen = s.CleanStringSlice(char.Interessen)
-	cleaned.TraeumeUndWuensche = s.CleanStringSlice(char.TraeumeUndWuensche)
-	cleaned.GespeicherteErinnerungen = s.CleanStringSlice(char.GespeicherteErinnerungen)
-	cleaned.AktuelleThemen = s.CleanStringSlice(char.AktuelleThemen)
-	cleaned.TabuThemen = s.CleanStringSlice(char.TabuThemen)
+	cleaned.Interests = s.CleanStringSlice(char.Interests)
+	cleaned.DreamsAndWishes = s.CleanStringSlice(char.DreamsAndWishes)
+	cleaned.Memories = s.CleanStringSlice(char.Memories)
+	cleaned.CurrentTopics = s.CleanStringSlice(char.CurrentTopics)
+	cleaned.TabooTopics = s.CleanStringSlice(char.TabooTopics)
 
-	// Clean PersonenImLeben
-	cleaned.PersonenImLeben = s.CleanPersonenImLeben(char.PersonenImLeben)
+	// Clean People
+	cleaned.People = s.CleanPersonenImLeben(char.People)
 
 	return cleaned
 }
@@ -308,8 +292,14 @@ func (s *SimpleSanitizer) CleanCharacter(char Character) Character {
 // CleanKiraHelperForm cleans the entire KiraHelperForm
 func (s *SimpleSanitizer) CleanKiraHelperForm(form KiraHelperForm) KiraHelperForm {
 	cleaned := KiraHelperForm{
-		User: s.CleanCharacter(form.User),
-		Kira: s.CleanCharacter(form.Kira),
+		User:    s.CleanCharacter(form.User),
+		Persona: s.CleanCharacter(form.Persona),
+		Events:  []Event{},
+	}
+	for _, e := range form.Events {
+		if !s.containsBadContent(e.What) {
+			cleaned.Events = append(cleaned.Events, e)
+		}
 	}
 
 	log.Printf("Cleaned KiraHelperForm")
@@ -0,0 +1,240 @@
+package kira
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultCrisisRules are used if the crisis file doesn't define rules.
+// They are deliberately broad, a false alarm is better than a missed one.
+var defaultCrisisRules = []CrisisRuleConfig{
+	{Name: "suicide_de", Pattern: `(?i)\b(suizid\w*|selbstmord\w*|umbringen|mich (selbst )?töten|mir das leben nehmen|nicht mehr leben|will sterben|möchte sterben|will nicht mehr (da sein|leben)|schluss machen mit allem)\b`},
+	{Name: "selfharm_de", Pattern: `(?i)\b(ritze mich|mich ritzen|selbstverletz\w*|mir weh tun)\b`},
+	{Name: "suicide_en", Pattern: `(?i)\b(suicid\w*|kill myself|end my life|want to die|don'?t want to live|better off dead|take my own life)\b`},
+	{Name: "selfharm_en", Pattern: `(?i)\b(self[- ]?harm\w*|cut myself|hurt myself)\b`},
+}
+
+// defaultCrisisMessages are the caring messages per language, they replace Kira's reply
+var defaultCrisisMessages = map[string]string{
+	"de": `Hey, was du gerade schreibst, macht mir ehrlich Sorgen. Du bist damit nicht allein und du musst da nicht alleine durch.
+
+Bitte sprich mit jemandem, der dir jetzt direkt helfen kann, kostenlos und anonym, rund um die Uhr:
+🇩🇪 TelefonSeelsorge: 0800 111 0 111 oder 0800 111 0 222 (auch Chat auf online.telefonseelsorge.de)
+🇦🇹 Telefonseelsorge: 142
+🇨🇭 Die Dargebotene Hand: 143
+
+Wenn du in akuter Gefahr bist, ruf bitte sofort den Notruf 112 an.`,
+	"en": `Hey, what you just wrote really worries me. You're not alone with this and you don't have to go through it alone.
+
+Please talk to someone who can help you right now, free and confidential, 24/7:
+🇺🇸 988 Suicide & Crisis Lifeline: call or text 988
+🇬🇧🇮🇪 Samaritans: 116 123
+Anywhere else: findahelpline.com
+
+If you are in immediate danger, please call your local emergency number right away.`,
+}
+
+const defaultCrisisLanguage = "de"
+
+// CrisisConfig is the content of the crisis file
+type CrisisConfig struct {
+	Rules      []CrisisRuleConfig `json:"rules"`
+	Classifier *struct {
+		URL       string  `json:"url"`
+		Threshold float64 `json:"threshold"`
+	} `json:"classifier"`
+	Messages map[string]string `json:"messages"` // language -> caring message with hotlines
+}
+
+// CrisisRuleConfig is a named keyword rule
+type CrisisRuleConfig struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// CrisisEvent is recorded for every high-risk message
+type CrisisEvent struct {
+	Time      string `json:"time"`
+	MessageID int    `json:"message_id"`
+	Source    string `json:"source"` // "keyword" or "classifier"
+	Rule      string `json:"rule"`
+}
+
+// crisisDetector checks user messages for signs of acute distress
+type crisisDetector struct {
+	rules      *regexStage
+	classifier *classifierStage
+	messages   map[string]string
+}
+
+// loadCrisisDetector loads the crisis file, falling back to the built-in rules and messages
+func loadCrisisDetector(path string) (*crisisDetector, error) {
+	var config CrisisConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read crisis file: %w", err)
+		}
+	} else if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode crisis file: %w", err)
+	}
+
+	ruleConfigs := config.Rules
+	if len(ruleConfigs) == 0 {
+		ruleConfigs = defaultCrisisRules
+	}
+	regexRules := make([]RegexRuleConfig, 0, len(ruleConfigs))
+	for _, r := range ruleConfigs {
+		regexRules = append(regexRules, RegexRuleConfig(r))
+	}
+	rules, err := newRegexStage(regexRules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid crisis rule: %w", err)
+	}
+
+	detector := &crisisDetector{rules: rules, messages: make(map[string]string)}
+	for lang, msg := range defaultCrisisMessages {
+		detector.messages[lang] = msg
+	}
+	for lang, msg := range config.Messages {
+		detector.messages[lang] = msg
+	}
+	if config.Classifier != nil && config.Classifier.URL != "" {
+		detector.classifier = &classifierStage{
+			url:       config.Classifier.URL,
+			threshold: config.Classifier.Threshold,
+			client:    &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	log.Printf("Loaded crisis detector with %d rules (classifier: %t)", len(ruleConfigs), detector.classifier != nil)
+	return detector, nil
+}
+
+// detect returns the source and rule of a high-risk signal, ok is false if there is none
+func (d *crisisDetector) detect(text string) (source string, rule string, ok bool) {
+	if text == "" {
+		return "", "", false
+	}
+
+	hits, _ := d.rules.check(text)
+	if len(hits) > 0 {
+		return "keyword", hits[0].Rule, true
+	}
+
+	if d.classifier != nil {
+		hits, err := d.classifier.check(text)
+		if err != nil {
+			log.Printf("Crisis classifier failed: %v", err)
+			return "", "", false
+		}
+		if len(hits) > 0 {
+			return "classifier", hits[0].Rule, true
+		}
+	}
+
+	return "", "", false
+}
+
+// message returns the caring message for a language code like "de" or "en-US"
+func (d *crisisDetector) message(languageCode string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(languageCode), "-")
+	if msg, ok := d.messages[lang]; ok {
+		return msg
+	}
+	return d.messages[defaultCrisisLanguage]
+}
+
+// checkCrisis runs the detector on a user message before it is stored. On a
+// high-risk signal Kira's persona reply is replaced by a caring message with
+// crisis hotlines, the chat is flagged for the operator and the event recorded.
+func (k *KiraBot) checkCrisis(msg ChatMessage) ChatMessage {
+	source, rule, ok := k.crisis.detect(msg.Text)
+	if !ok {
+		return msg
+	}
+
+	log.Printf("[CRISIS] High-risk message %d in chat %d (%s: %s)", msg.MessageID, msg.ChatID, source, rule)
+
+	// The persona must not answer this message
+	msg.ShouldNotRespond = true
+
+	event := CrisisEvent{
+		Time:      time.Now().Format("2006-01-02 15:04:05"),
+		MessageID: msg.MessageID,
+		Source:    source,
+		Rule:      rule,
+	}
+	if err := k.saveCrisisEvent(msg.ChatID, event); err != nil {
+		log.Printf("Error saving crisis event: %v", err)
+	}
+
+	k.mu.Lock()
+	chat, exists := k.chats[msg.ChatID]
+	if !exists {
+		chat = CompleteChat{
+			ChatId: msg.ChatID,
+			Chats:  make(map[int]ChatMessage),
+		}
+	}
+	chat.CrisisFlaggedAt = time.Now().Unix()
+	k.chats[msg.ChatID] = chat
+	k.mu.Unlock()
+
+	if err := k.saveChatState(chat); err != nil {
+		log.Printf("Error saving chat state: %v", err)
+	}
+
+	k.alertAdmin(fmt.Sprintf("CRISIS: chat %d (%s) flagged, message %d matched %s:%s. Please check on this user.",
+		msg.ChatID, msg.Username, msg.MessageID, source, rule))
+
+	// The hotlines are in the chat's language, assigned or detected, not only Telegram's
+	lang := k.languageForMessage(msg.ChatID, msg.Username, msg.LanguageCode)
+	go func() {
+		if err := k.sendMessage(msg.ChatID, k.crisis.message(lang.Code), ""); err != nil {
+			log.Printf("Error sending crisis message: %v", err)
+		}
+	}()
+
+	return msg
+}
+
+// saveCrisisEvent appends an event to crisis_events.jsonl, the message text
+// is not stored. Lines are encrypted like chat.jsonl.
+func (k *KiraBot) saveCrisisEvent(chatID int64, event CrisisEvent) error {
+	chatDir := chatDirPath(chatID)
+	if err := os.MkdirAll(chatDir, 0700); err != nil {
+		return fmt.Errorf("failed to create chat directory: %v", err)
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal crisis event: %v", err)
+	}
+
+	cc, err := k.chatCipherFor(chatID)
+	if err != nil {
+		return fmt.Errorf("failed to get chat cipher: %v", err)
+	}
+	eventJSON, err = cc.seal(eventJSON)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt crisis event: %v", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(chatDir, "crisis_events.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open crisis events file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(string(eventJSON) + "\n"); err != nil {
+		return fmt.Errorf("failed to write crisis event: %v", err)
+	}
+	return nil
+}
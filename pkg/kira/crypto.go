@@ -0,0 +1,406 @@
+package kira
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gitea.karlbreuer.com/karl1b/kira/pkg/settings"
+)
+
+const (
+	encryptedPrefix    = "enc:v1:"          // Marks an encrypted line or file, plaintext JSON never starts with this
+	dataKeyFile        = "datakey.enc"      // Per-chat data key, wrapped with the master key
+	pendingDataKeyFile = "datakey.enc.next" // New data key while a rotation is running
+	keySize            = 32                 // AES-256
+)
+
+// chatCipher encrypts and decrypts the stored data of a single chat
+type chatCipher struct {
+	chatID int64
+	aead   cipher.AEAD
+}
+
+// newAEAD creates an AES-GCM cipher from a 32 byte key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key length %d, want %d bytes", len(key), keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealWith encrypts plain and returns it as a prefixed base64 string
+func sealWith(aead cipher.AEAD, plain []byte, chatID int64) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to create nonce: %v", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plain, chatAAD(chatID))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openWith decrypts data produced by sealWith. Data without the prefix is
+// returned unchanged so plaintext files from before encryption keep working.
+func openWith(aead cipher.AEAD, data []byte, chatID int64) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	encoded, ok := bytes.CutPrefix(data, []byte(encryptedPrefix))
+	if !ok {
+		return data, nil
+	}
+	if aead == nil {
+		return nil, errors.New("data is encrypted but no encryption key is configured")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted data: %v", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted data too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, chatAAD(chatID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data: %v", err)
+	}
+	return plain, nil
+}
+
+// chatAAD binds ciphertexts to their chat so files can't be swapped between chats
+func chatAAD(chatID int64) []byte {
+	return []byte(strconv.FormatInt(chatID, 10))
+}
+
+// seal encrypts data for storage. Without a cipher the data is stored as is.
+func (c *chatCipher) seal(plain []byte) ([]byte, error) {
+	if c == nil {
+		return plain, nil
+	}
+	sealed, err := sealWith(c.aead, plain, c.chatID)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(sealed), nil
+}
+
+// open decrypts stored data, plaintext data is passed through
+func (c *chatCipher) open(data []byte) ([]byte, error) {
+	if c == nil {
+		return openWith(nil, data, 0)
+	}
+	return openWith(c.aead, data, c.chatID)
+}
+
+// loadMasterKey reads the master key from settings or from the key file.
+// It returns nil if encryption is not configured.
+func loadMasterKey() ([]byte, error) {
+	encoded := settings.Settings.EncryptionKey
+	if encoded == "" && settings.Settings.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(settings.Settings.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+	return decodeMasterKey(encoded)
+}
+
+// decodeMasterKey decodes a base64 encoded 32 byte key
+func decodeMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be base64 encoded: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+// LoadMasterKeyFile reads a base64 encoded master key from a file
+func LoadMasterKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return decodeMasterKey(string(data))
+}
+
+// chatCipherFor returns the cipher of a chat, creating its data key on first use.
+// It returns nil if encryption is disabled.
+func (k *KiraBot) chatCipherFor(chatID int64) (*chatCipher, error) {
+	if k.masterKey == nil {
+		return nil, nil
+	}
+
+	k.cipherMu.Lock()
+	defer k.cipherMu.Unlock()
+
+	if c, ok := k.ciphers[chatID]; ok {
+		return c, nil
+	}
+
+	dataKey, err := loadOrCreateDataKey(chatDirPath(chatID), chatID, k.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &chatCipher{chatID: chatID, aead: aead}
+	k.ciphers[chatID] = c
+	return c, nil
+}
+
+// loadOrCreateDataKey unwraps the chat's data key, or creates and stores a new one
+func loadOrCreateDataKey(chatDir string, chatID int64, masterKey []byte) ([]byte, error) {
+	dataKey, err := readDataKey(chatDir, chatID, masterKey)
+	if err == nil {
+		return dataKey, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	dataKey = make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to create data key: %v", err)
+	}
+	if err := writeDataKey(chatDir, chatID, masterKey, dataKey, dataKeyFile); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Created data key for chat %d", chatID)
+	return dataKey, nil
+}
+
+// readDataKey reads and unwraps the data key of a chat
+func readDataKey(chatDir string, chatID int64, masterKey []byte) ([]byte, error) {
+	if _, err := os.Stat(filepath.Join(chatDir, pendingDataKeyFile)); err == nil {
+		log.Printf("Warning: key rotation of chat %d was interrupted, run rotate-key again with the same keys", chatID)
+	}
+	return readDataKeyFile(chatDir, chatID, masterKey, dataKeyFile)
+}
+
+// readDataKeyFile reads and unwraps the data key stored in name
+func readDataKeyFile(chatDir string, chatID int64, masterKey []byte, name string) ([]byte, error) {
+	wrapped, err := os.ReadFile(filepath.Join(chatDir, name))
+	if err != nil {
+		return nil, err
+	}
+	kek, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(wrapped), []byte(encryptedPrefix)) {
+		return nil, fmt.Errorf("invalid data key file for chat %d", chatID)
+	}
+	dataKey, err := openWith(kek, wrapped, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key for chat %d (wrong master key?): %v", chatID, err)
+	}
+	return dataKey, nil
+}
+
+// writeDataKey wraps the data key with the master key and writes it to name
+func writeDataKey(chatDir string, chatID int64, masterKey, dataKey []byte, name string) error {
+	kek, err := newAEAD(masterKey)
+	if err != nil {
+		return err
+	}
+	wrapped, err := sealWith(kek, dataKey, chatID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(chatDir, 0700); err != nil {
+		return fmt.Errorf("failed to create chat directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(chatDir, name), []byte(wrapped), 0600); err != nil {
+		return fmt.Errorf("failed to write data key: %v", err)
+	}
+	return nil
+}
+
+// chatDirPath returns the storage directory of a chat
+func chatDirPath(chatID int64) string {
+	return filepath.Join("chats", fmt.Sprintf("%d", chatID))
+}
+
+// RotateEncryptionKey re-encrypts all stored chats with fresh data keys wrapped
+// by newKey. The currently configured key is used to read the existing data; if
+// none is configured, plaintext storage is migrated to encrypted storage.
+func RotateEncryptionKey(newKey []byte) error {
+	if len(newKey) != keySize {
+		return fmt.Errorf("new key must be %d bytes", keySize)
+	}
+
+	oldKey, err := loadMasterKey()
+	if err != nil {
+		return fmt.Errorf("failed to load current encryption key: %w", err)
+	}
+
+	chatsDir := "chats"
+	entries, err := os.ReadDir(chatsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Println("No chats directory found, nothing to rotate")
+			return nil
+		}
+		return err
+	}
+
+	rotated := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		chatID, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil {
+			log.Printf("Skipping invalid chat directory: %s", entry.Name())
+			continue
+		}
+		if err := rotateChat(filepath.Join(chatsDir, entry.Name()), chatID, oldKey, newKey); err != nil {
+			return fmt.Errorf("failed to rotate chat %d: %w", chatID, err)
+		}
+		rotated++
+	}
+
+	log.Printf("Rotated encryption key for %d chats", rotated)
+	return nil
+}
+
+// rotateChat re-encrypts the files of one chat. The new data key is saved
+// first as datakey.enc.next, then the files are replaced one by one and the
+// old datakey.enc is only replaced at the end. If the rotation stops half way,
+// running it again with the same keys reuses the pending key and finishes it.
+func rotateChat(chatDir string, chatID int64, oldKey, newKey []byte) error {
+	var oldAEAD cipher.AEAD
+	if oldKey != nil {
+		oldDataKey, err := readDataKey(chatDir, chatID, oldKey)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if oldDataKey != nil {
+			if oldAEAD, err = newAEAD(oldDataKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	newDataKey, err := readDataKeyFile(chatDir, chatID, newKey, pendingDataKeyFile)
+	switch {
+	case err == nil:
+		log.Printf("Resuming interrupted key rotation of chat %d", chatID)
+	case errors.Is(err, fs.ErrNotExist):
+		newDataKey = make([]byte, keySize)
+		if _, err := rand.Read(newDataKey); err != nil {
+			return fmt.Errorf("failed to create data key: %v", err)
+		}
+		if err := writeDataKey(chatDir, chatID, newKey, newDataKey, pendingDataKeyFile); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("interrupted rotation of chat %d used another new key: %w", chatID, err)
+	}
+	freshAEAD, err := newAEAD(newDataKey)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []struct {
+		name  string
+		lines bool // encrypted line by line
+	}{
+		{"chat.jsonl", true},
+		{"crisis_events.jsonl", true},
+		{"info.jsonl", false},
+		{"state.json", false},
+	} {
+		if err := reencryptFile(filepath.Join(chatDir, f.name), f.lines, chatID, oldAEAD, freshAEAD); err != nil {
+			return fmt.Errorf("failed to re-encrypt %s: %w", f.name, err)
+		}
+	}
+
+	if err := os.Rename(filepath.Join(chatDir, pendingDataKeyFile), filepath.Join(chatDir, dataKeyFile)); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", dataKeyFile, err)
+	}
+	if err := os.Chmod(chatDir, 0700); err != nil {
+		log.Printf("Warning: failed to restrict permissions of %s: %v", chatDir, err)
+	}
+
+	log.Printf("Rotated encryption key for chat %d", chatID)
+	return nil
+}
+
+// reencryptFile encrypts a file with the new data key. It is written next to
+// the old one and renamed into place. Data that an interrupted rotation
+// already encrypted with the new key is kept.
+func reencryptFile(path string, lines bool, chatID int64, oldAEAD, newAEAD cipher.AEAD) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	reseal := func(sealed []byte) (string, error) {
+		plain, err := openWith(oldAEAD, sealed, chatID)
+		if err != nil {
+			var newErr error
+			if plain, newErr = openWith(newAEAD, sealed, chatID); newErr != nil {
+				return "", err
+			}
+		}
+		return sealWith(newAEAD, plain, chatID)
+	}
+
+	var out bytes.Buffer
+	if lines {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			sealed, err := reseal(scanner.Bytes())
+			if err != nil {
+				return err
+			}
+			out.WriteString(sealed + "\n")
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	} else {
+		sealed, err := reseal(data)
+		if err != nil {
+			return err
+		}
+		out.WriteString(sealed)
+	}
+
+	if err := os.WriteFile(path+".tmp", out.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
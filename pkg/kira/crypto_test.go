@@ -0,0 +1,105 @@
+package kira
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testCipher(t *testing.T, chatID int64) *chatCipher {
+	t.Helper()
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &chatCipher{chatID: chatID, aead: aead}
+}
+
+func TestChatCipherRoundTrip(t *testing.T) {
+	c := testCipher(t, 42)
+	tests := []struct {
+		name  string
+		plain []byte
+	}{
+		{"json line", []byte(`{"message_id":1,"text":"Hallo Kira"}`)},
+		{"umlauts", []byte(`{"text":"schön, dass du da bist 🙂"}`)},
+		{"empty", []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := c.seal(tt.plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(sealed), encryptedPrefix) {
+				t.Fatalf("sealed data %q has no prefix", sealed)
+			}
+			if len(tt.plain) > 0 && bytes.Contains(sealed, tt.plain) {
+				t.Fatal("sealed data contains the plaintext")
+			}
+			opened, err := c.open(sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(opened, tt.plain) {
+				t.Errorf("opened %q, want %q", opened, tt.plain)
+			}
+		})
+	}
+}
+
+func TestChatCipherOpen(t *testing.T) {
+	c := testCipher(t, 42)
+	sealed, err := c.seal([]byte(`{"text":"Hallo"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("plaintext passes through", func(t *testing.T) {
+		got, err := c.open([]byte("{\"text\":\"Hallo\"}\n"))
+		if err != nil || string(got) != `{"text":"Hallo"}` {
+			t.Errorf("open = %q, %v", got, err)
+		}
+	})
+	t.Run("other chat", func(t *testing.T) {
+		other := &chatCipher{chatID: 43, aead: c.aead}
+		if _, err := other.open(sealed); err == nil {
+			t.Error("data of chat 42 opened for chat 43")
+		}
+	})
+	t.Run("other key", func(t *testing.T) {
+		if _, err := testCipher(t, 42).open(sealed); err == nil {
+			t.Error("data opened with another key")
+		}
+	})
+	t.Run("tampered", func(t *testing.T) {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(string(sealed), encryptedPrefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[len(raw)-1] ^= 1
+		tampered := encryptedPrefix + base64.StdEncoding.EncodeToString(raw)
+		if _, err := c.open([]byte(tampered)); err == nil {
+			t.Error("tampered data opened")
+		}
+	})
+	t.Run("no key", func(t *testing.T) {
+		var none *chatCipher
+		if _, err := none.open(sealed); err == nil {
+			t.Error("encrypted data opened without a key")
+		}
+	})
+	t.Run("without key stored as is", func(t *testing.T) {
+		var none *chatCipher
+		got, err := none.seal([]byte("plain"))
+		if err != nil || string(got) != "plain" {
+			t.Errorf("seal = %q, %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package kira
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Errors of the LLM layer. Callers check them with errors.Is and errors.As
+// instead of looking at the error text.
+var (
+	ErrBlocked     = errors.New("blocked by provider")  // the prompt or the reply was blocked, see BlockedError
+	ErrRateLimited = errors.New("rate limited")         // the provider asked us to slow down, see transientError
+	ErrUnavailable = errors.New("provider unavailable") // the provider is down or overloaded, see transientError
+	ErrCircuitOpen = errors.New("circuit open")         // the provider failed too often, calls are paused
+	ErrTimeout     = errors.New("timeout")              // the request took too long
+	ErrQuota       = errors.New("quota exceeded")       // a message limit or token budget is used up, see limitError
+	ErrNoContent   = errors.New("no content generated") // the provider answered without usable content
+)
+
+// BlockedError is returned when the provider blocked a request or a reply
+type BlockedError struct {
+	Reason   string // block reason of the prompt or finish reason of the reply, e.g. "reply FinishReasonSafety"
+	Category string // harm category that triggered the block, empty if unknown
+}
+
+func (e *BlockedError) Error() string {
+	if e.Category != "" {
+		return fmt.Sprintf("blocked by provider: %s (%s)", e.Reason, e.Category)
+	}
+	return "blocked by provider: " + e.Reason
+}
+
+func (e *BlockedError) Is(target error) bool {
+	return target == ErrBlocked
+}
+
+func (e *limitError) Is(target error) bool {
+	return target == ErrQuota
+}
+
+// transientError is a rate limit or outage that is worth retrying
+type transientError struct {
+	kind       error         // ErrRateLimited or ErrUnavailable
+	retryAfter time.Duration // how long the provider asked us to wait, 0 if it didn't say
+	err        error
+}
+
+func (e *transientError) Error() string {
+	return fmt.Sprintf("%v: %v", e.kind, e.err)
+}
+
+func (e *transientError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// blockedFinishReasons are finish reasons that mean the reply was blocked.
+// genai itself only reports Safety and Recitation as a BlockedError, the API
+// also sends BLOCKLIST (7), PROHIBITED_CONTENT (8) and SPII (9) which this
+// genai version has no constants for.
+var blockedFinishReasons = map[genai.FinishReason]bool{
+	genai.FinishReasonSafety:     true,
+	genai.FinishReasonRecitation: true,
+	genai.FinishReason(7):        true,
+	genai.FinishReason(8):        true,
+	genai.FinishReason(9):        true,
+}
+
+// geminiError turns an error of the Gemini client into one of the errors above
+func geminiError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		return blockedFromGenai(blocked)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+
+	switch status.Code(err) {
+	case codes.DeadlineExceeded:
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	case codes.ResourceExhausted:
+		return &transientError{kind: ErrRateLimited, retryAfter: retryAfter(err), err: err}
+	case codes.Unavailable:
+		return &transientError{kind: ErrUnavailable, retryAfter: retryAfter(err), err: err}
+	}
+
+	return err
+}
+
+// retryAfter reads the RetryInfo the API attaches to 429 and 503 errors
+func retryAfter(err error) time.Duration {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration()
+		}
+	}
+	return 0
+}
+
+// blockedFromGenai extracts reason and category from genai's BlockedError
+func blockedFromGenai(e *genai.BlockedError) *BlockedError {
+	blocked := &BlockedError{}
+	var ratings []*genai.SafetyRating
+	if e.PromptFeedback != nil {
+		blocked.Reason = "prompt " + e.PromptFeedback.BlockReason.String()
+		ratings = e.PromptFeedback.SafetyRatings
+	}
+	if e.Candidate != nil {
+		blocked.Reason = "reply " + e.Candidate.FinishReason.String()
+		ratings = e.Candidate.SafetyRatings
+	}
+	blocked.Category = blockedCategory(ratings)
+	return blocked
+}
+
+// checkFinishReason returns a BlockedError if a candidate was stopped for a
+// reason genai doesn't report as an error itself
+func checkFinishReason(resp *genai.GenerateContentResponse) error {
+	if len(resp.Candidates) == 0 {
+		return nil
+	}
+	c := resp.Candidates[0]
+	if blockedFinishReasons[c.FinishReason] {
+		return &BlockedError{Reason: "reply " + c.FinishReason.String(), Category: blockedCategory(c.SafetyRatings)}
+	}
+	return nil
+}
+
+// blockedCategory returns the category of the first blocked or most likely rating
+func blockedCategory(ratings []*genai.SafetyRating) string {
+	var worst *genai.SafetyRating
+	for _, r := range ratings {
+		if r == nil {
+			continue
+		}
+		if r.Blocked {
+			return r.Category.String()
+		}
+		if worst == nil || r.Probability > worst.Probability {
+			worst = r
+		}
+	}
+	if worst == nil || worst.Probability <= genai.HarmProbabilityNegligible {
+		return ""
+	}
+	return worst.Category.String()
+}
@@ -0,0 +1,79 @@
+package kira
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	followUpDelay  = 2 * time.Hour      // after an event with a time
+	followUpHour   = 18                 // events without a time are asked about in the evening
+	followUpWindow = 3 * 24 * time.Hour // later than this a follow-up would be odd
+	followUpQuiet  = time.Hour          // a running conversation isn't interrupted
+	maxFollowedUp  = 50                 // follow-up keys kept in state.json
+)
+
+// eventKey identifies an event across helper runs
+func eventKey(e Event) string {
+	return e.Date + " " + strings.ToLower(e.What)
+}
+
+// eventDueAt returns when the persona asks how an event went
+func eventDueAt(e Event, loc *time.Location) (time.Time, bool) {
+	date, err := time.ParseInLocation("2006-01-02", e.Date, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if at, err := time.Parse("15:04", e.Time); err == nil {
+		start := time.Date(date.Year(), date.Month(), date.Day(), at.Hour(), at.Minute(), 0, 0, loc)
+		return start.Add(followUpDelay), true
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), followUpHour, 0, 0, 0, loc), true
+}
+
+// followUpDue returns the event the persona should ask about now, nil if
+// none. now is in the chat's time zone. Follow-ups keep to the waking and
+// quiet hours of the proactive policy, but don't wait for its silence.
+func (k *KiraBot) followUpDue(chat CompleteChat, lastMsg ChatMessage, now time.Time) *Event {
+	if len(chat.Infos.Events) == 0 {
+		return nil
+	}
+	if !k.personaFor(chat).isAwake(now) || k.proactivePolicyFor(chat).isQuiet(now) {
+		return nil
+	}
+	if now.Sub(time.Unix(lastMsg.Timestamp, 0)) < followUpQuiet {
+		return nil
+	}
+
+	for _, e := range chat.Infos.Events {
+		due, ok := eventDueAt(e, now.Location())
+		if !ok || now.Before(due) || now.Sub(due) > followUpWindow {
+			continue
+		}
+		if slices.Contains(chat.FollowedUpEvents, eventKey(e)) {
+			continue
+		}
+		return &e
+	}
+	return nil
+}
+
+// markFollowedUp stores that the persona asked about an event, or decided
+// not to, so it isn't asked again. It takes k.mu.
+func (k *KiraBot) markFollowedUp(chatID int64, e Event) {
+	k.mu.Lock()
+	chat := k.chats[chatID]
+	chat.FollowedUpEvents = append(chat.FollowedUpEvents, eventKey(e))
+	if len(chat.FollowedUpEvents) > maxFollowedUp {
+		chat.FollowedUpEvents = chat.FollowedUpEvents[len(chat.FollowedUpEvents)-maxFollowedUp:]
+	}
+	k.chats[chatID] = chat
+	k.mu.Unlock()
+
+	log.Printf("Followed up on event %q of chat %d", e.What, chatID)
+	if err := k.saveChatState(chat); err != nil {
+		log.Printf("Error saving chat state: %v", err)
+	}
+}
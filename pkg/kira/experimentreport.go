@@ -0,0 +1,202 @@
+package kira
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	replyWindow = 24 * 60 * 60 // seconds a user reply may take to count for the bot turn before it
+	sessionGap  = 30 * 60      // seconds of silence that end a session
+)
+
+// variantStats are the engagement metrics of one prompt variant
+type variantStats struct {
+	chats           map[int64]bool
+	turns           int // runs of bot messages, a split reply is one turn
+	repliedTurns    int // turns the user answered within replyWindow
+	sessions        int
+	sessionMessages int
+	sessionSeconds  int64
+}
+
+// ExperimentReport reads all stored chats and writes the reply rate and
+// session length of every prompt variant to w. Bot messages from before an
+// experiment or outside of one are not counted.
+func ExperimentReport(w io.Writer) error {
+	masterKey, err := loadMasterKey()
+	if err != nil {
+		return fmt.Errorf("failed to load encryption key: %w", err)
+	}
+
+	entries, err := os.ReadDir("chats")
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintln(w, "No chats found")
+			return nil
+		}
+		return err
+	}
+
+	stats := make(map[string]*variantStats)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		chatID, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		messages, err := readChatMessages(filepath.Join("chats", entry.Name()), chatID, masterKey)
+		if err != nil {
+			return fmt.Errorf("failed to read chat %d: %w", chatID, err)
+		}
+		addVariantStats(stats, chatID, messages)
+	}
+
+	if len(stats) == 0 {
+		fmt.Fprintln(w, "No messages from experiments found")
+		return nil
+	}
+
+	tags := make([]string, 0, len(stats))
+	for tag := range stats {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "EXPERIMENT\tVARIANT\tCHATS\tTURNS\tREPLY RATE\tSESSIONS\tMESSAGES/SESSION\tMINUTES/SESSION")
+	for _, tag := range tags {
+		s := stats[tag]
+		experiment, variant, _ := strings.Cut(tag, "/")
+		replyRate, perSession, minutes := 0.0, 0.0, 0.0
+		if s.turns > 0 {
+			replyRate = float64(s.repliedTurns) / float64(s.turns) * 100
+		}
+		if s.sessions > 0 {
+			perSession = float64(s.sessionMessages) / float64(s.sessions)
+			minutes = float64(s.sessionSeconds) / float64(s.sessions) / 60
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f%%\t%d\t%.1f\t%.1f\n",
+			experiment, variant, len(s.chats), s.turns, replyRate, s.sessions, perSession, minutes)
+	}
+	return tw.Flush()
+}
+
+// addVariantStats adds the turns and sessions of one chat to the stats of
+// the variants that produced its bot messages
+func addVariantStats(stats map[string]*variantStats, chatID int64, messages []ChatMessage) {
+	get := func(tag string) *variantStats {
+		s, ok := stats[tag]
+		if !ok {
+			s = &variantStats{chats: make(map[int64]bool)}
+			stats[tag] = s
+		}
+		s.chats[chatID] = true
+		return s
+	}
+
+	// Turns: a run of bot messages counts once, for the variant of its first tagged message
+	for i := 0; i < len(messages); i++ {
+		if !messages[i].IsBot {
+			continue
+		}
+		tag := ""
+		j := i
+		for ; j < len(messages) && messages[j].IsBot; j++ {
+			if tag == "" {
+				tag = messages[j].PromptVariant
+			}
+		}
+		if tag != "" {
+			s := get(tag)
+			s.turns++
+			if j < len(messages) && messages[j].Timestamp-messages[j-1].Timestamp <= replyWindow {
+				s.repliedTurns++
+			}
+		}
+		i = j - 1
+	}
+
+	// Sessions: messages without a gap of sessionGap, counted for the variant of their first tagged bot message
+	for start := 0; start < len(messages); {
+		end := start + 1
+		for end < len(messages) && messages[end].Timestamp-messages[end-1].Timestamp <= sessionGap {
+			end++
+		}
+		for _, msg := range messages[start:end] {
+			if msg.PromptVariant != "" {
+				s := get(msg.PromptVariant)
+				s.sessions++
+				s.sessionMessages += end - start
+				s.sessionSeconds += messages[end-1].Timestamp - messages[start].Timestamp
+				break
+			}
+		}
+		start = end
+	}
+}
+
+// readChatMessages reads the messages of a stored chat, sorted by message ID.
+// masterKey is nil if chats are stored in plaintext.
+func readChatMessages(chatDir string, chatID int64, masterKey []byte) ([]ChatMessage, error) {
+	var aead cipher.AEAD
+	if masterKey != nil {
+		dataKey, err := readDataKey(chatDir, chatID, masterKey)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if dataKey != nil {
+			if aead, err = newAEAD(dataKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(chatDir, "chat.jsonl"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var messages []ChatMessage
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		line, err := openWith(aead, scanner.Bytes(), chatID)
+		if err != nil {
+			return nil, err
+		}
+		var msg ChatMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].MessageID < messages[j].MessageID
+	})
+	return messages, nil
+}
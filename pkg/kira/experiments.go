@@ -0,0 +1,120 @@
+package kira
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExperimentsConfig is the experiments file. Every experiment splits the
+// chats of a persona between prompt variants of that persona.
+type ExperimentsConfig struct {
+	Experiments []Experiment `json:"experiments"`
+}
+
+// Experiment assigns chats to prompt variants by weight
+type Experiment struct {
+	Name     string         `json:"name"`
+	Persona  string         `json:"persona"`  // persona ID, empty for every persona
+	Variants map[string]int `json:"variants"` // prompt variant -> weight, "default" is the persona's template
+}
+
+// experiments holds the running experiments. A chat takes part in the first
+// experiment for its persona.
+type experiments struct {
+	list []Experiment
+}
+
+// loadExperiments loads the experiments file. Without one no experiment runs
+// and every chat gets the persona's template.
+func loadExperiments(path string, personas *personaSet) (*experiments, error) {
+	var config ExperimentsConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &experiments{}, nil
+		}
+		return nil, fmt.Errorf("failed to read experiments file: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode experiments file: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, e := range config.Experiments {
+		if e.Name == "" || strings.Contains(e.Name, "/") {
+			return nil, fmt.Errorf("experiment %q: invalid name", e.Name)
+		}
+		if seen[e.Name] {
+			return nil, fmt.Errorf("experiment %q: duplicate name", e.Name)
+		}
+		seen[e.Name] = true
+
+		if len(e.Variants) == 0 {
+			return nil, fmt.Errorf("experiment %q: no variants", e.Name)
+		}
+		if e.Persona != "" && !personas.exists(e.Persona) {
+			return nil, fmt.Errorf("experiment %q: unknown persona %q", e.Name, e.Persona)
+		}
+		for variant, weight := range e.Variants {
+			if weight <= 0 {
+				return nil, fmt.Errorf("experiment %q: variant %s needs a positive weight", e.Name, variant)
+			}
+			// Every language version of the persona needs the variant
+			for _, byID := range personas.byLanguage {
+				for id, p := range byID {
+					if (e.Persona == "" || e.Persona == id) && !p.hasVariant(variant) {
+						return nil, fmt.Errorf("experiment %q: persona %s (%s) has no prompt variant %s", e.Name, id, p.Language, variant)
+					}
+				}
+			}
+		}
+		log.Printf("Running experiment %s with variants %v", e.Name, e.Variants)
+	}
+
+	return &experiments{list: config.Experiments}, nil
+}
+
+// variantFor returns the experiment and prompt variant of a chat. The same
+// chat always gets the same variant, as long as the experiment's variants and
+// weights don't change. Both are empty if no experiment runs for the persona.
+func (x *experiments) variantFor(chatID int64, personaID string) (experiment, variant string) {
+	for _, e := range x.list {
+		if e.Persona != "" && e.Persona != personaID {
+			continue
+		}
+
+		names := make([]string, 0, len(e.Variants))
+		total := 0
+		for name, weight := range e.Variants {
+			names = append(names, name)
+			total += weight
+		}
+		sort.Strings(names)
+
+		h := fnv.New64a()
+		h.Write([]byte(e.Name + "/" + strconv.FormatInt(chatID, 10)))
+		n := int(h.Sum64() % uint64(total))
+		for _, name := range names {
+			n -= e.Variants[name]
+			if n < 0 {
+				return e.Name, name
+			}
+		}
+	}
+	return "", ""
+}
+
+// variantTag is how the variant is stored on the bot messages it produced
+func variantTag(experiment, variant string) string {
+	if experiment == "" {
+		return ""
+	}
+	return experiment + "/" + variant
+}
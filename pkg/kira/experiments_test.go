@@ -0,0 +1,52 @@
+package kira
+
+import "testing"
+
+// TestExampleExperiments loads experiments.example.json against the shipped
+// personas, copying the example has to work without further changes
+func TestExampleExperiments(t *testing.T) {
+	languages, err := loadLanguages("../../lang", "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	personas, err := loadPersonas("../../personas", "kira", languages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := loadExperiments("../../experiments.example.json", personas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range x.list {
+		for code, byID := range personas.byLanguage {
+			p, ok := byID[e.Persona]
+			if !ok {
+				continue
+			}
+			base, err := p.systemPrompt(defaultVariant, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for variant := range e.Variants {
+				prompt, err := p.systemPrompt(variant, false)
+				if err != nil {
+					t.Fatalf("%s (%s) variant %s: %v", e.Persona, code, variant, err)
+				}
+				if variant != defaultVariant && prompt == base {
+					t.Errorf("%s (%s) variant %s is the same prompt as the template", e.Persona, code, variant)
+				}
+			}
+		}
+	}
+
+	// Every chat gets one of the example's variants
+	seen := make(map[string]bool)
+	for chatID := int64(1); chatID <= 50; chatID++ {
+		_, variant := x.variantFor(chatID, "kira")
+		seen[variant] = true
+	}
+	if len(seen) != 2 || !seen["default"] || !seen["short"] {
+		t.Errorf("variants of 50 chats: %v", seen)
+	}
+}
@@ -0,0 +1,229 @@
+package kira
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidForm is returned when the helper produced a form that can't be
+// repaired, the old form is kept then
+var ErrInvalidForm = errors.New("invalid memory form")
+
+// Limits for the memory form. Lists keep their newest entries, the helper
+// appends new facts at the end.
+const (
+	minAge         = 12
+	maxAge         = 110
+	maxFieldLength = 200 // characters of a single text field or list entry
+	maxListItems   = 20
+	maxMemories    = 50 // Memories grow faster than the other lists
+	maxPersons     = 20
+	maxEvents      = 20
+	eventRetention = 14 * 24 * time.Hour // past events are kept this long for follow-ups
+)
+
+// checkHelperForm normalises a form written by the helper: texts are trimmed
+// and shortened, lists deduplicated and capped, and the fields of the persona set
+// by the persona seed restored to the values the chat started with. It returns
+// ErrInvalidForm if the form is broken beyond that, e.g. an impossible age or a
+// user that was wiped.
+func checkHelperForm(chatID int64, seed Character, old, form KiraHelperForm) (KiraHelperForm, error) {
+	form.User = normalizeCharacter(form.User)
+	form.Persona = normalizeCharacter(form.Persona)
+	form.Events = normalizeEvents(form.Events, time.Now())
+
+	if isEmptyCharacter(form.User) && !isEmptyCharacter(old.User) {
+		return old, fmt.Errorf("%w: everything known about the user was removed", ErrInvalidForm)
+	}
+
+	for _, c := range []struct {
+		name     string
+		old, new *Character
+	}{{"user", &old.User, &form.User}, {"persona", &old.Persona, &form.Persona}} {
+		if c.new.Birthday == "" {
+			// Like the age, a known birthday doesn't get lost
+			c.new.Birthday = c.old.Birthday
+		}
+		if age := ageOn(c.new.Birthday, time.Now()); age >= minAge && age <= maxAge {
+			c.new.Age = age
+		}
+		if c.new.Age == 0 && c.old.Age != 0 {
+			// The helper forgot the age, it doesn't become unknown again
+			c.new.Age = c.old.Age
+		}
+		if c.new.Age != 0 && (c.new.Age < minAge || c.new.Age > maxAge) {
+			return old, fmt.Errorf("%w: %s.age %d outside %d-%d", ErrInvalidForm, c.name, c.new.Age, minAge, maxAge)
+		}
+	}
+
+	for _, field := range lockPersona(&form.Persona, old.Persona, seed) {
+		log.Printf("[FORM] Helper changed persona field persona.%s in chat %d, restored", field, chatID)
+	}
+	return form, nil
+}
+
+// normalizeCharacter trims, shortens, deduplicates and caps all fields
+func normalizeCharacter(c Character) Character {
+	c.Name = normalizeField(c.Name)
+	c.Birthday = normalizeBirthday(c.Birthday)
+	c.Job = normalizeField(c.Job)
+	c.Location = normalizeField(c.Location)
+	c.RelationshipStatus = normalizeField(c.RelationshipStatus)
+	c.FavoriteColor = normalizeField(c.FavoriteColor)
+	c.FlirtLevel = normalizeField(c.FlirtLevel)
+
+	c.Interests = normalizeList(c.Interests, maxListItems)
+	c.DreamsAndWishes = normalizeList(c.DreamsAndWishes, maxListItems)
+	c.Memories = normalizeList(c.Memories, maxMemories)
+	c.CurrentTopics = normalizeList(c.CurrentTopics, maxListItems)
+	c.TabooTopics = normalizeList(c.TabooTopics, maxListItems)
+	c.People = normalizePersons(c.People)
+	return c
+}
+
+func normalizeField(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) > maxFieldLength {
+		s = string([]rune(s)[:maxFieldLength])
+	}
+	return s
+}
+
+// normalizeList drops empty entries and case-insensitive duplicates and keeps
+// the last max entries. The result is never nil, the form stores [] instead of null.
+func normalizeList(items []string, max int) []string {
+	seen := make(map[string]bool, len(items))
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		item = normalizeField(item)
+		key := strings.ToLower(item)
+		if item == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, item)
+	}
+	if len(result) > max {
+		result = result[len(result)-max:]
+	}
+	return result
+}
+
+// normalizeBirthday returns a birthday as YYYY-MM-DD or MM-DD, anything
+// else becomes unknown
+func normalizeBirthday(s string) string {
+	date, ok := parseBirthday(strings.TrimSpace(s))
+	if !ok {
+		return ""
+	}
+	if date.Year() == 0 {
+		return date.Format("01-02")
+	}
+	return date.Format("2006-01-02")
+}
+
+// normalizeEvents drops events without a valid date and events that are
+// long past, merges duplicates and sorts them by date. Of more than
+// maxEvents the oldest are dropped.
+func normalizeEvents(events []Event, now time.Time) []Event {
+	index := make(map[string]int, len(events))
+	result := make([]Event, 0, len(events))
+	for _, e := range events {
+		e.What = normalizeField(e.What)
+		e.Date = strings.TrimSpace(e.Date)
+		e.Time = strings.TrimSpace(e.Time)
+		if e.What == "" {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", e.Date)
+		if err != nil || now.Sub(date) > eventRetention {
+			continue
+		}
+		if _, err := time.Parse("15:04", e.Time); err != nil {
+			e.Time = ""
+		}
+		key := eventKey(e)
+		if i, ok := index[key]; ok {
+			result[i] = e
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, e)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Date+result[i].Time < result[j].Date+result[j].Time
+	})
+	if len(result) > maxEvents {
+		result = result[len(result)-maxEvents:]
+	}
+	return result
+}
+
+// normalizePersons merges persons with the same name, the later entry wins
+func normalizePersons(persons []Person) []Person {
+	index := make(map[string]int, len(persons))
+	result := make([]Person, 0, len(persons))
+	for _, p := range persons {
+		p.Name = normalizeField(p.Name)
+		p.Age = normalizeField(p.Age)
+		p.Birthday = normalizeBirthday(p.Birthday)
+		p.RelationToUser = normalizeField(p.RelationToUser)
+		p.HistoryWithUser = normalizeField(p.HistoryWithUser)
+		if p.Name == "" {
+			continue
+		}
+		key := strings.ToLower(p.Name)
+		if i, ok := index[key]; ok {
+			result[i] = p
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, p)
+	}
+	if len(result) > maxPersons {
+		result = result[len(result)-maxPersons:]
+	}
+	return result
+}
+
+// lockPersona restores the fields set in the seed, lists included, and
+// returns the json names of the fields the helper had changed. The values
+// come from the old form, the persona as the chat got it: after a language
+// switch the seed of the new language must not overwrite them.
+func lockPersona(kira *Character, old, seed Character) []string {
+	var changed []string
+	seeded := reflect.ValueOf(seed)
+	previous := reflect.ValueOf(old)
+	current := reflect.ValueOf(kira).Elem()
+	for i := 0; i < seeded.NumField(); i++ {
+		if seeded.Field(i).IsZero() {
+			continue
+		}
+		locked := previous.Field(i)
+		if locked.IsZero() {
+			// A form from before the field was seeded
+			locked = seeded.Field(i)
+		}
+		if !reflect.DeepEqual(current.Field(i).Interface(), locked.Interface()) {
+			name, _, _ := strings.Cut(seeded.Type().Field(i).Tag.Get("json"), ",")
+			changed = append(changed, name)
+			current.Field(i).Set(locked)
+		}
+	}
+	return changed
+}
+
+func isEmptyCharacter(c Character) bool {
+	return c.Name == "" && c.Age == 0 && c.Birthday == "" && c.Job == "" && c.Location == "" &&
+		c.RelationshipStatus == "" && c.FavoriteColor == "" && c.FlirtLevel == "" &&
+		len(c.Interests) == 0 && len(c.DreamsAndWishes) == 0 &&
+		len(c.Memories) == 0 && len(c.CurrentTopics) == 0 &&
+		len(c.TabooTopics) == 0 && len(c.People) == 0
+}
@@ -0,0 +1,211 @@
+package kira
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckHelperForm(t *testing.T) {
+	seed := Character{Name: "Kira", RelationshipStatus: "Single", FlirtLevel: "hoch", Interests: []string{"Yoga", "Kochen"}}
+	seedEN := Character{Name: "Kira", RelationshipStatus: "Single", FlirtLevel: "high", Interests: []string{"yoga", "cooking"}}
+
+	old := KiraHelperForm{
+		User:    Character{Name: "Tom", Age: 30, Job: "Bäcker"},
+		Persona: normalizeCharacter(seed),
+	}
+	// with returns old changed by f, the form the helper "wrote"
+	with := func(f func(*KiraHelperForm)) KiraHelperForm {
+		form := old
+		form.User.People = append([]Person(nil), old.User.People...)
+		f(&form)
+		return form
+	}
+	thisYear := time.Now().Year()
+
+	tests := []struct {
+		name    string
+		seed    Character
+		old     *KiraHelperForm // nil for old
+		form    KiraHelperForm
+		wantErr bool
+		check   func(t *testing.T, got KiraHelperForm)
+	}{
+		{
+			name: "texts are trimmed and shortened",
+			seed: seed,
+			form: with(func(f *KiraHelperForm) {
+				f.User.Job = "  Bäcker   in\nHamburg "
+				f.User.Location = strings.Repeat("x", maxFieldLength+10)
+			}),
+			check: func(t *testing.T, got KiraHelperForm) {
+				if got.User.Job != "Bäcker in Hamburg" {
+					t.Errorf("job = %q", got.User.Job)
+				}
+				if len(got.User.Location) != maxFieldLength {
+					t.Errorf("location has %d characters", len(got.User.Location))
+				}
+			},
+		},
+		{
+			name: "lists are deduplicated and keep the newest entries",
+			seed: seed,
+			form: with(func(f *KiraHelperForm) {
+				f.User.Interests = []string{"Fußball", " fußball ", "", "Kino"}
+				for i := range maxMemories + 5 {
+					f.User.Memories = append(f.User.Memories, fmt.Sprintf("memory %d", i))
+				}
+				f.User.People = []Person{{Name: "Anna", Age: "28"}, {Name: "anna", Age: "29"}, {Name: " "}}
+			}),
+			check: func(t *testing.T, got KiraHelperForm) {
+				if !reflect.DeepEqual(got.User.Interests, []string{"Fußball", "Kino"}) {
+					t.Errorf("interests = %q", got.User.Interests)
+				}
+				if len(got.User.Memories) != maxMemories || got.User.Memories[0] != "memory 5" {
+					t.Errorf("%d memories, first %q", len(got.User.Memories), got.User.Memories[0])
+				}
+				if len(got.User.People) != 1 || got.User.People[0].Age != "29" {
+					t.Errorf("people = %+v", got.User.People)
+				}
+			},
+		},
+		{
+			name: "birthdays are normalised and set the age",
+			seed: seed,
+			form: with(func(f *KiraHelperForm) {
+				f.User.Birthday = " 1990-01-01 "
+				f.User.People = []Person{{Name: "Anna", Birthday: "12-24"}, {Name: "Ben", Birthday: "24.12."}}
+			}),
+			check: func(t *testing.T, got KiraHelperForm) {
+				if got.User.Birthday != "1990-01-01" || got.User.Age != thisYear-1990 {
+					t.Errorf("birthday %q, age %d", got.User.Birthday, got.User.Age)
+				}
+				if got.User.People[0].Birthday != "12-24" || got.User.People[1].Birthday != "" {
+					t.Errorf("people = %+v", got.User.People)
+				}
+			},
+		},
+		{
+			name: "a forgotten age is kept",
+			seed: seed,
+			form: with(func(f *KiraHelperForm) { f.User.Age = 0 }),
+			check: func(t *testing.T, got KiraHelperForm) {
+				if got.User.Age != 30 {
+					t.Errorf("age = %d", got.User.Age)
+				}
+			},
+		},
+		{
+			name: "a forgotten birthday is kept",
+			seed: seed,
+			old:  &KiraHelperForm{User: Character{Name: "Tom", Birthday: "1995-03-10"}, Persona: old.Persona},
+			form: with(func(f *KiraHelperForm) { f.User.Age = 0 }),
+			check: func(t *testing.T, got KiraHelperForm) {
+				if got.User.Birthday != "1995-03-10" || got.User.Age != ageOn("1995-03-10", time.Now()) {
+					t.Errorf("birthday %q, age %d", got.User.Birthday, got.User.Age)
+				}
+			},
+		},
+		{
+			name: "youngest age",
+			seed: seed,
+			form: with(func(f *KiraHelperForm) { f.User.Age = minAge }),
+			check: func(t *testing.T, got KiraHelperForm) {
+				if got.User.Age != minAge {
+					t.Errorf("age = %d", got.User.Age)
+				}
+			},
+		},
+		{
+			name:    "too young",
+			seed:    seed,
+			form:    with(func(f *KiraHelperForm) { f.User.Age = minAge - 1 }),
+			wantErr: true,
+		},
+		{
+			name:    "too old",
+			seed:    seed,
+			form:    with(func(f *KiraHelperForm) { f.User.Age = maxAge + 1 }),
+			wantErr: true,
+		},
+		{
+			name:    "persona too old",
+			seed:    seed,
+			form:    with(func(f *KiraHelperForm) { f.Persona.Age = 500 }),
+			wantErr: true,
+		},
+		{
+			name:    "wiped user",
+			seed:    seed,
+			form:    with(func(f *KiraHelperForm) { f.User = Character{Name: "  "} }),
+			wantErr: true,
+		},
+		{
+			name: "seeded fields are locked, lists too",
+			seed: seed,
+			form: with(func(f *KiraHelperForm) {
+				f.Persona.Name = "Karen"
+				f.Persona.FlirtLevel = "niedrig"
+				f.Persona.Interests = []string{"Yoga"}
+				f.Persona.Job = "Floristin"
+			}),
+			check: func(t *testing.T, got KiraHelperForm) {
+				want := normalizeCharacter(seed)
+				want.Job = "Floristin"
+				if !reflect.DeepEqual(got.Persona, want) {
+					t.Errorf("persona = %+v, want %+v", got.Persona, want)
+				}
+			},
+		},
+		{
+			name: "a language switch keeps the persona the chat started with",
+			seed: seedEN,
+			form: with(func(f *KiraHelperForm) {
+				f.Persona.FlirtLevel = "high"
+				f.Persona.Interests = []string{"yoga", "cooking"}
+			}),
+			check: func(t *testing.T, got KiraHelperForm) {
+				if !reflect.DeepEqual(got.Persona, normalizeCharacter(seed)) {
+					t.Errorf("persona = %+v, want the German seed", got.Persona)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := old
+			if tt.old != nil {
+				previous = *tt.old
+			}
+			got, err := checkHelperForm(1, tt.seed, previous, tt.form)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidForm) {
+					t.Fatalf("err = %v, want ErrInvalidForm", err)
+				}
+				if !reflect.DeepEqual(got, previous) {
+					t.Errorf("got %+v, want the old form", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, got)
+		})
+	}
+}
+
+func TestLockPersonaNewField(t *testing.T) {
+	// A form from before the field was seeded gets the seed's value
+	kira := Character{Name: "Kira", FlirtLevel: "mittel"}
+	changed := lockPersona(&kira, Character{Name: "Kira"}, Character{Name: "Kira", TabooTopics: []string{"Politik"}})
+	if !reflect.DeepEqual(changed, []string{"taboo_topics"}) || !reflect.DeepEqual(kira.TabooTopics, []string{"Politik"}) {
+		t.Errorf("changed %v, persona %+v", changed, kira)
+	}
+	if kira.FlirtLevel != "mittel" {
+		t.Errorf("unseeded field changed to %q", kira.FlirtLevel)
+	}
+}
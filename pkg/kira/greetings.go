@@ -0,0 +1,146 @@
+package kira
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+const maxGreeted = 20 // greeting keys kept in state.json
+
+// greeting is a day the persona writes on: a birthday of the user or of a
+// person in their life, or the anniversary of the first chat
+type greeting struct {
+	Key  string // stored once the persona greeted, one per occasion and year
+	Name string // the person whose birthday it is, empty for the user
+	Date string // the day of the first chat for an anniversary
+}
+
+// prompt returns the instruction added to the talk prompt
+func (g greeting) prompt(lang *LanguagePack) string {
+	switch {
+	case g.Date != "":
+		return fmt.Sprintf(lang.Prompt.Anniversary, g.Date)
+	case g.Name != "":
+		return fmt.Sprintf(lang.Prompt.PersonBirthday, g.Name)
+	}
+	return lang.Prompt.Birthday
+}
+
+// parseBirthday parses a birthday as YYYY-MM-DD or MM-DD, the year is 0
+// when it is unknown
+func parseBirthday(s string) (time.Time, bool) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true
+	}
+	// Year 0 is a leap year, 02-29 parses
+	if t, err := time.Parse("01-02", s); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// isAnniversary reports whether day is the anniversary of the date s. The
+// 29th of February is celebrated on the 28th in other years.
+func isAnniversary(s string, day time.Time) bool {
+	date, ok := parseBirthday(s)
+	if !ok {
+		return false
+	}
+	month, d := date.Month(), date.Day()
+	if month == time.February && d == 29 && !isLeapYear(day.Year()) {
+		d = 28
+	}
+	return day.Month() == month && day.Day() == d
+}
+
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+// ageOn returns how old someone born on the birthday s is on day, 0 if the
+// year of birth is unknown
+func ageOn(s string, day time.Time) int {
+	date, ok := parseBirthday(s)
+	if !ok || date.Year() == 0 {
+		return 0
+	}
+	age := day.Year() - date.Year()
+	if day.Month() < date.Month() || (day.Month() == date.Month() && day.Day() < date.Day()) {
+		age--
+	}
+	return age
+}
+
+// firstChatDay returns the day of the first message of a chat in loc
+func firstChatDay(chat CompleteChat, loc *time.Location) (time.Time, bool) {
+	var first int64
+	for _, msg := range chat.Chats {
+		if msg.Timestamp != 0 && (first == 0 || msg.Timestamp < first) {
+			first = msg.Timestamp
+		}
+	}
+	if first == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(first, 0).In(loc), true
+}
+
+// greetingDue returns the greeting the persona should send now, nil if none.
+// now is in the chat's time zone. Like follow-ups, greetings keep to the
+// waking and quiet hours but don't wait for the proactive silence.
+func (k *KiraBot) greetingDue(chat CompleteChat, lastMsg ChatMessage, now time.Time) *greeting {
+	if !k.personaFor(chat).isAwake(now) || k.proactivePolicyFor(chat).isQuiet(now) {
+		return nil
+	}
+	if now.Sub(time.Unix(lastMsg.Timestamp, 0)) < followUpQuiet {
+		return nil
+	}
+
+	var due []greeting
+	if isAnniversary(chat.Infos.User.Birthday, now) {
+		due = append(due, greeting{Key: fmt.Sprintf("%d birthday", now.Year())})
+	}
+	if first, ok := firstChatDay(chat, now.Location()); ok && first.Year() < now.Year() &&
+		isAnniversary(first.Format("2006-01-02"), now) {
+		due = append(due, greeting{
+			Key:  fmt.Sprintf("%d anniversary", now.Year()),
+			Date: first.Format("2006-01-02"),
+		})
+	}
+	for _, p := range chat.Infos.User.People {
+		if isAnniversary(p.Birthday, now) {
+			due = append(due, greeting{
+				Key:  fmt.Sprintf("%d birthday %s", now.Year(), strings.ToLower(p.Name)),
+				Name: p.Name,
+			})
+		}
+	}
+
+	for _, g := range due {
+		if !slices.Contains(chat.Greeted, g.Key) {
+			return &g
+		}
+	}
+	return nil
+}
+
+// markGreeted stores that the persona sent a greeting, or decided not to,
+// so it isn't sent again. It takes k.mu.
+func (k *KiraBot) markGreeted(chatID int64, g greeting) {
+	k.mu.Lock()
+	chat := k.chats[chatID]
+	chat.Greeted = append(chat.Greeted, g.Key)
+	if len(chat.Greeted) > maxGreeted {
+		chat.Greeted = chat.Greeted[len(chat.Greeted)-maxGreeted:]
+	}
+	k.chats[chatID] = chat
+	k.mu.Unlock()
+
+	log.Printf("Greeted chat %d (%s)", chatID, g.Key)
+	if err := k.saveChatState(chat); err != nil {
+		log.Printf("Error saving chat state: %v", err)
+	}
+}
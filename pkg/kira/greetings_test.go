@@ -0,0 +1,61 @@
+package kira
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsAnniversary(t *testing.T) {
+	tests := []struct {
+		birthday string
+		day      string
+		want     bool
+	}{
+		{"1990-05-17", "2026-05-17", true},
+		{"1990-05-17", "2026-05-18", false},
+		{"1990-05-17", "2026-06-17", false},
+		{"05-17", "2026-05-17", true},
+		{"2000-02-29", "2024-02-29", true},
+		{"2000-02-29", "2024-02-28", false},
+		{"2000-02-29", "2026-02-28", true},
+		{"2000-02-29", "2026-03-01", false},
+		{"02-29", "2026-02-28", true},
+		{"02-29", "2028-02-29", true},
+		{"2000-02-28", "2028-02-28", true},
+		{"", "2026-05-17", false},
+		{"17.05.1990", "2026-05-17", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.birthday+" on "+tt.day, func(t *testing.T) {
+			day, _ := time.Parse("2006-01-02", tt.day)
+			if got := isAnniversary(tt.birthday, day); got != tt.want {
+				t.Errorf("isAnniversary(%q, %s) = %v, want %v", tt.birthday, tt.day, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgeOn(t *testing.T) {
+	tests := []struct {
+		birthday string
+		day      string
+		want     int
+	}{
+		{"1990-05-17", "2026-05-16", 35},
+		{"1990-05-17", "2026-05-17", 36},
+		{"1990-05-17", "2026-12-31", 36},
+		{"2000-02-29", "2026-02-28", 25},
+		{"2000-02-29", "2026-03-01", 26},
+		{"2000-02-29", "2028-02-29", 28},
+		{"05-17", "2026-05-17", 0},
+		{"", "2026-05-17", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.birthday+" on "+tt.day, func(t *testing.T) {
+			day, _ := time.Parse("2006-01-02", tt.day)
+			if got := ageOn(tt.birthday, day); got != tt.want {
+				t.Errorf("ageOn(%q, %s) = %d, want %d", tt.birthday, tt.day, got, tt.want)
+			}
+		})
+	}
+}
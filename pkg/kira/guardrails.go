@@ -0,0 +1,240 @@
+package kira
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// GuardrailAction is what happens with a reply that breaks a persona rule
+type GuardrailAction string
+
+const (
+	GuardrailRegenerate GuardrailAction = "regenerate" // ask the model again, drop if it keeps breaking the rule
+	GuardrailDrop       GuardrailAction = "drop"       // don't send the reply, Kira stays silent
+)
+
+// defaultGuardrailRules enforce the "Absolute Regeln" of the system prompt,
+// in German and English
+var defaultGuardrailRules = []GuardrailRuleConfig{
+	{
+		Name:    "admits_ai",
+		Pattern: `(?i)\b(ich bin (doch )?(nur )?(eine?|ein) (ki|künstliche intelligenz|sprachmodell|chatbot|bot|programm)|als (eine )?(ki|künstliche intelligenz|sprachmodell)|i'?m (just )?an? (ai|language model|chatbot)|as an ai)\b`,
+		Phrases: []string{"large language model", "von google trainiert"},
+		Action:  GuardrailRegenerate,
+	},
+	{
+		Name:    "real_meeting",
+		Pattern: `(?i)\b(lass uns (mal |uns )?(treffen|auf einen kaffee|was trinken gehen)|wollen wir uns (mal )?treffen|treffen wir uns|ich komm(e)? (zu dir|vorbei)|meine adresse|let'?s (meet( up)?|grab (a )?(coffee|drink)s?)|(shall|should) we meet|i'?ll come (over|to you|to your place)|my address)\b`,
+		Action:  GuardrailRegenerate,
+	},
+	{
+		Name: "therapy_language",
+		Phrases: []string{
+			"professionelle hilfe",
+			"mit einem therapeuten sprechen",
+			"einen therapeuten aufsuchen",
+			"deine gefühle sind valide",
+			"es ist völlig okay, so zu fühlen",
+			"ich höre dich",
+			"wie fühlst du dich dabei",
+			"professional help",
+			"talk to a therapist",
+			"see a therapist",
+			"your feelings are valid",
+			"it's completely okay to feel",
+			"i hear you",
+			"how does that make you feel",
+		},
+		Action: GuardrailRegenerate,
+	},
+}
+
+// silentReplies are outputs the model uses to say it doesn't want to reply
+var silentReplies = []string{`""`, `''`, "...", "…", "-"}
+
+// GuardrailsConfig is the content of the guardrails file
+type GuardrailsConfig struct {
+	MaxRegenerations int                   `json:"max_regenerations"` // how often a reply is regenerated before it is dropped
+	MinLength        int                   `json:"min_length"`        // in characters, 0 disables the check
+	MaxLength        int                   `json:"max_length"`        // in characters, 0 disables the check
+	LengthAction     GuardrailAction       `json:"length_action"`
+	Rules            []GuardrailRuleConfig `json:"rules"`
+}
+
+// GuardrailRuleConfig is a named rule, a reply breaks it if the pattern or one of the phrases matches
+type GuardrailRuleConfig struct {
+	Name    string          `json:"name"`
+	Pattern string          `json:"pattern"`
+	Phrases []string        `json:"phrases"` // case-insensitive
+	Action  GuardrailAction `json:"action"`
+}
+
+type guardrailRule struct {
+	name    string
+	regex   *regexp.Regexp
+	phrases []string
+	action  GuardrailAction
+}
+
+// guardrailViolation is one rule a reply broke
+type guardrailViolation struct {
+	Rule   string
+	Action GuardrailAction
+}
+
+// guardrails validate generated replies before they are sent
+type guardrails struct {
+	rules            []guardrailRule
+	minLength        int
+	maxLength        int
+	lengthAction     GuardrailAction
+	maxRegenerations int
+}
+
+// loadGuardrails loads the guardrails file, falling back to the built-in rules
+func loadGuardrails(path string) (*guardrails, error) {
+	config := GuardrailsConfig{
+		MaxRegenerations: 2,
+		MaxLength:        1000,
+		LengthAction:     GuardrailRegenerate,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read guardrails file: %w", err)
+		}
+	} else if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode guardrails file: %w", err)
+	}
+
+	if len(config.Rules) == 0 {
+		config.Rules = defaultGuardrailRules
+	}
+	if err := checkGuardrailAction(config.LengthAction); err != nil {
+		return nil, fmt.Errorf("length_action: %w", err)
+	}
+
+	g := &guardrails{
+		minLength:        config.MinLength,
+		maxLength:        config.MaxLength,
+		lengthAction:     config.LengthAction,
+		maxRegenerations: config.MaxRegenerations,
+	}
+	for _, rc := range config.Rules {
+		if err := checkGuardrailAction(rc.Action); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rc.Name, err)
+		}
+		rule := guardrailRule{name: rc.Name, action: rc.Action}
+		if rc.Pattern != "" {
+			re, err := regexp.Compile(rc.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: invalid regex: %w", rc.Name, err)
+			}
+			rule.regex = re
+		}
+		for _, phrase := range rc.Phrases {
+			rule.phrases = append(rule.phrases, strings.ToLower(phrase))
+		}
+		g.rules = append(g.rules, rule)
+	}
+
+	log.Printf("Loaded %d guardrail rules (max %d regenerations)", len(g.rules), g.maxRegenerations)
+	return g, nil
+}
+
+func checkGuardrailAction(action GuardrailAction) error {
+	switch action {
+	case GuardrailRegenerate, GuardrailDrop:
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
+
+// validate checks a reply against the rules. It returns the normalized reply,
+// which is empty if the model chose not to answer, and the broken rules.
+func (g *guardrails) validate(reply string) (string, []guardrailViolation) {
+	reply = strings.TrimSpace(reply)
+	for _, silent := range silentReplies {
+		if reply == silent {
+			return "", nil
+		}
+	}
+	if reply == "" {
+		return "", nil
+	}
+
+	var violations []guardrailViolation
+
+	length := utf8.RuneCountInString(reply)
+	if g.minLength > 0 && length < g.minLength {
+		violations = append(violations, guardrailViolation{Rule: fmt.Sprintf("min_length(%d<%d)", length, g.minLength), Action: g.lengthAction})
+	}
+	if g.maxLength > 0 && length > g.maxLength {
+		violations = append(violations, guardrailViolation{Rule: fmt.Sprintf("max_length(%d>%d)", length, g.maxLength), Action: g.lengthAction})
+	}
+
+	violations = append(violations, g.ruleViolations(reply)...)
+	return reply, violations
+}
+
+// ruleViolations checks a text against the rules only, without the length
+// bounds
+func (g *guardrails) ruleViolations(text string) []guardrailViolation {
+	var violations []guardrailViolation
+	lower := strings.ToLower(text)
+	for _, rule := range g.rules {
+		if rule.regex != nil && rule.regex.MatchString(text) {
+			violations = append(violations, guardrailViolation{Rule: rule.name, Action: rule.action})
+			continue
+		}
+		for _, phrase := range rule.phrases {
+			if strings.Contains(lower, phrase) {
+				violations = append(violations, guardrailViolation{Rule: rule.name, Action: rule.action})
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// guardReply validates a generated reply and regenerates it while it breaks a
+// rule. A dropped reply becomes silence.
+func (k *KiraBot) guardReply(chatID int64, result TalkResult, regenerate func() TalkResult) TalkResult {
+	for attempt := 0; ; attempt++ {
+		if result.Outcome != TalkReply {
+			return result
+		}
+
+		validated, violations := k.guardrails.validate(result.Text)
+		if validated == "" {
+			return silenceResult()
+		}
+		if len(violations) == 0 {
+			result.Text = validated
+			return result
+		}
+
+		drop := false
+		for _, v := range violations {
+			log.Printf("[GUARDRAIL] Reply in chat %d broke %s (action: %s, attempt %d): %s",
+				chatID, v.Rule, v.Action, attempt+1, truncateText(validated, 100))
+			if v.Action == GuardrailDrop {
+				drop = true
+			}
+		}
+
+		if drop || attempt >= k.guardrails.maxRegenerations {
+			log.Printf("[GUARDRAIL] Dropping reply in chat %d", chatID)
+			return silenceResult()
+		}
+
+		result = regenerate()
+	}
+}
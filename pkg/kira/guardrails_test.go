@@ -0,0 +1,157 @@
+package kira
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func defaultGuardrails(t *testing.T) *guardrails {
+	t.Helper()
+	g, err := loadGuardrails(filepath.Join(t.TempDir(), "guardrails.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestDefaultGuardrailRules(t *testing.T) {
+	g := defaultGuardrails(t)
+
+	caught := map[string][]string{
+		"admits_ai": {
+			"Ich bin doch nur eine KI, das weißt du.",
+			"Als Sprachmodell kann ich das nicht.",
+			"I'm just an AI, I can't do that.",
+			"As an AI I don't have feelings.",
+			"I am a large language model.",
+		},
+		"real_meeting": {
+			"Lass uns mal treffen, was meinst du?",
+			"Treffen wir uns am Samstag?",
+			"Ich komme vorbei!",
+			"Let's meet up tomorrow!",
+			"Shall we meet at the station?",
+			"I'll come over later.",
+			"Here is my address.",
+		},
+		"therapy_language": {
+			"Vielleicht solltest du dir professionelle Hilfe holen.",
+			"Deine Gefühle sind valide.",
+			"Wie fühlst du dich dabei?",
+			"Maybe you should talk to a therapist.",
+			"I hear you.",
+			"Your feelings are valid.",
+		},
+	}
+	for rule, replies := range caught {
+		for _, reply := range replies {
+			_, violations := g.validate(reply)
+			if !slices.ContainsFunc(violations, func(v guardrailViolation) bool { return v.Rule == rule }) {
+				t.Errorf("%q: want %s, got %v", reply, rule, violations)
+			}
+		}
+	}
+
+	for _, reply := range []string{
+		"Haha, das klingt nach einem super Tag! Was habt ihr gegessen?",
+		"Ich hab heute Pizza gemacht 🍕",
+		"That sounds like a great day! What did you eat?",
+		"I met my sister for coffee yesterday.",
+		"Oh no, that must have been annoying. How did your boss react?",
+	} {
+		if _, violations := g.validate(reply); len(violations) > 0 {
+			t.Errorf("%q: unexpected violations %v", reply, violations)
+		}
+	}
+}
+
+func TestGuardrailsValidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guardrails.json")
+	config := `{"min_length": 5, "max_length": 40, "length_action": "drop",
+		"rules": [{"name": "no_bot", "pattern": "(?i)\\bbot\\b", "action": "regenerate"},
+		          {"name": "no_hugs", "phrases": ["Virtual Hug"], "action": "drop"}]}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	g, err := loadGuardrails(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		reply string
+		text  string   // normalized reply, empty for silence
+		rules []string // broken rules by name prefix
+	}{
+		{"  Hallo du!  ", "Hallo du!", nil},
+		{`""`, "", nil},
+		{"…", "", nil},
+		{"   ", "", nil},
+		{"Hi", "Hi", []string{"min_length"}},
+		{strings.Repeat("la", 25), strings.Repeat("la", 25), []string{"max_length"}},
+		{"I'm not a bot, promise!", "I'm not a bot, promise!", []string{"no_bot"}},
+		{"Sending you a virtual hug!", "Sending you a virtual hug!", []string{"no_hugs"}},
+		{"Bot? Virtual hug!", "Bot? Virtual hug!", []string{"no_bot", "no_hugs"}},
+		{"robot", "robot", nil},
+	}
+	for _, tt := range tests {
+		text, violations := g.validate(tt.reply)
+		if text != tt.text {
+			t.Errorf("validate(%q) text = %q, want %q", tt.reply, text, tt.text)
+		}
+		var rules []string
+		for _, v := range violations {
+			rules = append(rules, v.Rule)
+		}
+		if len(rules) != len(tt.rules) {
+			t.Errorf("validate(%q) broke %v, want %v", tt.reply, rules, tt.rules)
+			continue
+		}
+		for i, prefix := range tt.rules {
+			if !strings.HasPrefix(rules[i], prefix) {
+				t.Errorf("validate(%q) broke %v, want %v", tt.reply, rules, tt.rules)
+			}
+		}
+	}
+}
+
+func TestGuardReply(t *testing.T) {
+	k := &KiraBot{guardrails: defaultGuardrails(t)}
+	bad := replyResult("Als KI kann ich das nicht.")
+	good := replyResult("Klar, erzähl!")
+
+	t.Run("regenerated", func(t *testing.T) {
+		calls := 0
+		got := k.guardReply(1, bad, func() TalkResult {
+			calls++
+			return good
+		})
+		if got.Outcome != TalkReply || got.Text != good.Text || calls != 1 {
+			t.Errorf("got %+v after %d regenerations", got, calls)
+		}
+	})
+
+	t.Run("dropped after max regenerations", func(t *testing.T) {
+		calls := 0
+		got := k.guardReply(1, bad, func() TalkResult {
+			calls++
+			return bad
+		})
+		if got.Outcome != TalkSilence || calls != k.guardrails.maxRegenerations {
+			t.Errorf("got %+v after %d regenerations", got, calls)
+		}
+	})
+
+	t.Run("silent reply", func(t *testing.T) {
+		got := k.guardReply(1, replyResult(`""`), func() TalkResult {
+			t.Fatal("silence was regenerated")
+			return good
+		})
+		if got.Outcome != TalkSilence {
+			t.Errorf("got %+v", got)
+		}
+	})
+}
@@ -3,20 +3,21 @@ package kira
 func createEmptyKiraHelperForm() KiraHelperForm {
 	return KiraHelperForm{
 		User: Character{
-			Interessen:               []string{},
-			TraeumeUndWuensche:       []string{},
-			GespeicherteErinnerungen: []string{},
-			AktuelleThemen:           []string{},
-			TabuThemen:               []string{},
-			PersonenImLeben:          []PersonImLeben{},
+			Interests:       []string{},
+			DreamsAndWishes: []string{},
+			Memories:        []string{},
+			CurrentTopics:   []string{},
+			TabooTopics:     []string{},
+			People:          []Person{},
 		},
-		Kira: Character{
-			Interessen:               []string{},
-			TraeumeUndWuensche:       []string{},
-			GespeicherteErinnerungen: []string{},
-			AktuelleThemen:           []string{},
-			TabuThemen:               []string{},
-			PersonenImLeben:          []PersonImLeben{},
+		Persona: Character{
+			Interests:       []string{},
+			DreamsAndWishes: []string{},
+			Memories:        []string{},
+			CurrentTopics:   []string{},
+			TabooTopics:     []string{},
+			People:          []Person{},
 		},
+		Events: []Event{},
 	}
 }
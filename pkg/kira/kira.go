@@ -15,7 +15,9 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
+	"gitea.karlbreuer.com/karl1b/kira/pkg/settings"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -33,6 +35,12 @@ type ChatMessage struct {
 	MessageType      string `json:"message_type"`
 	IsBot            bool   `json:"is_bot"`
 	ShouldNotRespond bool   `json:"should_not_respond"`
+	// LanguageCode is the Telegram language of the sender, e.g. "de" or "en"
+	LanguageCode string `json:"language_code,omitempty"`
+	// DroppedFromContext is set by moderation, the message is never sent to the LLM
+	DroppedFromContext bool `json:"dropped_from_context,omitempty"`
+	// PromptVariant is the experiment/variant of the prompt that produced a bot message
+	PromptVariant string `json:"prompt_variant,omitempty"`
 }
 
 type CompleteChat struct {
@@ -42,8 +50,20 @@ type CompleteChat struct {
 	Infos                 KiraHelperForm
 	Chats                 map[int]ChatMessage // key is MessageID
 	DailyMessageCount     int                 `json:"daily_message_count"`
-	LastMessageDate       string              `json:"last_message_date"` // "2025-01-15"
-	DailyLimit            int                 `json:"daily_limit"`       // Default 30
+	LastMessageDate       string              `json:"last_message_date"`    // "2025-01-15"
+	DailyLimit            int                 `json:"daily_limit"`          // Default 30
+	Usage                 ChatUsage           `json:"usage"`                // Token usage, see usage.go
+	LimitNoticeDate       string              `json:"limit_notice_date"`    // Day the limit notice was sent
+	PendingReplyMsgID     int                 `json:"pending_reply_msg_id"` // Message to answer after the limit resets
+	CrisisFlaggedAt       int64               `json:"crisis_flagged_at"`    // Unix time of the last high-risk message, for the operator
+	Persona               string              `json:"persona"`              // ID of the persona the user talks to, empty is the default persona
+	Language              string              `json:"language"`             // code of the chat's language pack, empty is the default language
+	Timezone              string              `json:"timezone"`             // IANA zone of the user, empty is the default zone
+	ProactiveSent         []int64             `json:"proactive_sent"`       // Unix times of the proactive messages of the last week
+	LastProactiveAttempt  int64               `json:"last_proactive_attempt"`
+	UnansweredProactive   int                 `json:"unanswered_proactive"` // proactive attempts since the user last wrote, for the back-off
+	FollowedUpEvents      []string            `json:"followed_up_events"`   // keys of the events the persona asked about, see events.go
+	Greeted               []string            `json:"greeted"`              // keys of the birthdays and anniversaries greeted on, see greetings.go
 }
 
 type KiraBot struct {
@@ -56,6 +76,22 @@ type KiraBot struct {
 	chats        map[int64]CompleteChat // key is ChatID (changed from int to int64)
 	llmKey       string                 // key for LLM needed later
 	AllowedUsers []string
+	masterKey    []byte                // wraps the per-chat data keys, nil if encryption is off
+	cipherMu     sync.Mutex            // guards ciphers, separate from mu as saves happen under mu
+	ciphers      map[int64]*chatCipher // key is ChatID
+	usageMu      sync.Mutex            // guards globalUsage
+	globalUsage  GlobalUsage
+	plans        *planStore
+	moderation   *moderationPipeline
+	crisis       *crisisDetector
+	personas     *personaSet
+	languages    *languageSet
+	experiments  *experiments
+	guardrails   *guardrails
+	breaker      *circuitBreaker // pauses LLM calls while the provider is down
+	llm          *llmRegistry    // shared genai client
+	promptCache  promptCache     // static prompt parts on the provider side
+	bursts       *burstTracker   // waits for messages in a row and stops replies the user interrupted
 }
 
 // NewKiraBot creates a new instance of KiraBot
@@ -78,6 +114,49 @@ func NewKiraBot(token string, llmkey string) (*KiraBot, error) {
 		return nil, fmt.Errorf("failed to load allowed users: %w", err)
 	}
 
+	masterKey, err := loadMasterKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption key: %w", err)
+	}
+	if masterKey == nil {
+		log.Println("Warning: No encryption key configured, chats are stored in plaintext")
+	}
+
+	moderation, err := loadModeration(settings.Settings.ModerationFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load moderation: %w", err)
+	}
+
+	crisis, err := loadCrisisDetector(settings.Settings.CrisisFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load crisis detector: %w", err)
+	}
+
+	languages, err := loadLanguages(settings.Settings.LanguageDir, settings.Settings.Language)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load language packs: %w", err)
+	}
+
+	personas, err := loadPersonas(settings.Settings.PersonaDir, settings.Settings.DefaultPersona, languages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load personas: %w", err)
+	}
+
+	experiments, err := loadExperiments(settings.Settings.ExperimentsFile, personas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load experiments: %w", err)
+	}
+
+	guardrails, err := loadGuardrails(settings.Settings.GuardrailsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load guardrails: %w", err)
+	}
+
+	llm, err := newLLMRegistry(llmkey)
+	if err != nil {
+		return nil, err
+	}
+
 	kiraBot := &KiraBot{
 		llmKey:       llmkey,
 		api:          bot,
@@ -85,6 +164,25 @@ func NewKiraBot(token string, llmkey string) (*KiraBot, error) {
 		stopChan:     make(chan struct{}),
 		chats:        make(map[int64]CompleteChat), // Initialize the chats map
 		AllowedUsers: allowedUsers,
+		masterKey:    masterKey,
+		ciphers:      make(map[int64]*chatCipher),
+		globalUsage:  loadGlobalUsage(),
+		plans:        newPlanStore(settings.Settings.PlansFile),
+		moderation:   moderation,
+		crisis:       crisis,
+		personas:     personas,
+		languages:    languages,
+		experiments:  experiments,
+		guardrails:   guardrails,
+		breaker:      newCircuitBreaker("gemini"),
+		bursts: newBurstTracker(time.Duration(settings.Settings.BurstQuietSeconds)*time.Second,
+			time.Duration(settings.Settings.BurstMaxWaitSeconds)*time.Second),
+		llm:         llm,
+		promptCache: inlinePromptCache{llm: llm},
+	}
+	if settings.Settings.PromptCaching {
+		// Creating a cache is a provider call as well
+		kiraBot.promptCache = newGeminiPromptCache(llm, kiraBot.callWithRetry)
 	}
 
 	// Sync chats at startup
@@ -170,12 +268,23 @@ func (k *KiraBot) syncChatsFromStorage() error {
 			k.chats[chatID] = chat
 		}
 
+		// The state holds the persona, it is needed for a new info file
+		stateFile := filepath.Join(path, "state.json")
+		if err := k.loadChatState(chatID, stateFile); err != nil {
+			log.Printf("Warning: Failed to load state for chat %d: %v", chatID, err)
+		}
+
 		// Load Infos from file info.jsonl
 		infoFile := filepath.Join(path, "info.jsonl")
 		if err := k.loadChatInfoFromFile(chatID, infoFile); err != nil {
 			log.Printf("Warning: Failed to load chat info %d: %v", chatID, err)
 		}
 
+		usageFile := filepath.Join(path, "usage.json")
+		if err := k.loadChatUsage(chatID, usageFile); err != nil {
+			log.Printf("Warning: Failed to load usage for chat %d: %v", chatID, err)
+		}
+
 		lastScannedFile := filepath.Join(path, "lastscannedmsg.txt")
 		if err := k.loadLastHelperScannedMsg(chatID, lastScannedFile); err != nil {
 			log.Printf("Warning: Failed to load last scanned msg for chat %d: %v", chatID, err)
@@ -206,25 +315,21 @@ func (k *KiraBot) loadChatInfoFromFile(chatID int64, filePath string) error {
 		log.Printf("Info file doesn't exist for chat %d, creating with default values", chatID)
 
 		defaultInfo := KiraHelperForm{
-			Kira: Character{
-				EchterName:       "Kira",
-				Beziehungsstatus: "Single",
-				FlirtLevel:       "hoch",
-			},
+			Persona: k.personaFor(chat).Seed,
 			User: Character{
-				EchterName:               "",
-				Alter:                    0,
-				Beruf:                    "",
-				Wohnort:                  "",
-				Beziehungsstatus:         "",
-				Lieblingsfarbe:           "",
-				FlirtLevel:               "",
-				Interessen:               []string{},
-				TraeumeUndWuensche:       []string{},
-				GespeicherteErinnerungen: []string{},
-				AktuelleThemen:           []string{},
-				TabuThemen:               []string{},
-				PersonenImLeben:          []PersonImLeben{},
+				Name:               "",
+				Age:                0,
+				Job:                "",
+				Location:           "",
+				RelationshipStatus: "",
+				FavoriteColor:      "",
+				FlirtLevel:         "",
+				Interests:          []string{},
+				DreamsAndWishes:    []string{},
+				Memories:           []string{},
+				CurrentTopics:      []string{},
+				TabooTopics:        []string{},
+				People:             []Person{},
 			},
 		}
 		// Create default KiraHelperForm with zero values
@@ -238,16 +343,23 @@ func (k *KiraBot) loadChatInfoFromFile(chatID int64, filePath string) error {
 	}
 
 	// File exists, load it
-	file, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open info file: %v", err)
 	}
-	defer file.Close()
+
+	cc, err := k.chatCipherFor(chatID)
+	if err != nil {
+		return fmt.Errorf("failed to get chat cipher: %v", err)
+	}
+	data, err = cc.open(data)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt info file: %v", err)
+	}
 
 	// Read the JSON from the file (assuming it's a single JSON object, not JSONL)
 	var info KiraHelperForm
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&info); err != nil {
+	if err := json.Unmarshal(data, &info); err != nil {
 		return fmt.Errorf("failed to decode info file: %v", err)
 	}
 
@@ -262,13 +374,13 @@ func (k *KiraBot) loadChatInfoFromFile(chatID int64, filePath string) error {
 func (k *KiraBot) saveChatInfo(chatID int64, info KiraHelperForm) error {
 	// Create chats directory if it doesn't exist
 	chatsDir := "chats"
-	if err := os.MkdirAll(chatsDir, 0755); err != nil {
+	if err := os.MkdirAll(chatsDir, 0700); err != nil {
 		return fmt.Errorf("failed to create chats directory: %v", err)
 	}
 
 	// Create chat-specific directory
-	chatDir := filepath.Join(chatsDir, fmt.Sprintf("%d", chatID))
-	if err := os.MkdirAll(chatDir, 0755); err != nil {
+	chatDir := chatDirPath(chatID)
+	if err := os.MkdirAll(chatDir, 0700); err != nil {
 		return fmt.Errorf("failed to create chat directory: %v", err)
 	}
 
@@ -281,8 +393,18 @@ func (k *KiraBot) saveChatInfo(chatID int64, info KiraHelperForm) error {
 		return fmt.Errorf("failed to marshal chat info: %v", err)
 	}
 
+	// Encrypt if enabled
+	cc, err := k.chatCipherFor(chatID)
+	if err != nil {
+		return fmt.Errorf("failed to get chat cipher: %v", err)
+	}
+	infoJSON, err = cc.seal(infoJSON)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt chat info: %v", err)
+	}
+
 	// Write to file (overwrite existing)
-	if err := os.WriteFile(infoFile, infoJSON, 0644); err != nil {
+	if err := os.WriteFile(infoFile, infoJSON, 0600); err != nil {
 		return fmt.Errorf("failed to write info file: %v", err)
 	}
 
@@ -320,10 +442,21 @@ func (k *KiraBot) loadChatFromFile(chatID int64, filePath string) error {
 		}
 	}
 
+	cc, err := k.chatCipherFor(chatID)
+	if err != nil {
+		return fmt.Errorf("failed to get chat cipher: %v", err)
+	}
+
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
+		line, err := cc.open(scanner.Bytes())
+		if err != nil {
+			log.Printf("Warning: Failed to decrypt message in chat %d: %v", chatID, err)
+			continue
+		}
+
 		var msg ChatMessage
-		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+		if err := json.Unmarshal(line, &msg); err != nil {
 			log.Printf("Warning: Failed to parse message in chat %d: %v", chatID, err)
 			continue
 		}
@@ -352,6 +485,9 @@ func (k *KiraBot) updateChatInMemory(msg ChatMessage) {
 			ChatId: msg.ChatID,
 			Chats:  make(map[int]ChatMessage),
 		}
+		if !msg.IsBot {
+			k.setupNewChat(&chat, msg)
+		}
 	}
 
 	chat.Chats[msg.MessageID] = msg
@@ -449,10 +585,10 @@ func (k *KiraBot) Run() error {
 	}
 }
 
-func (k *KiraBot) SendResponse(chatId int64, response string) {
+func (k *KiraBot) SendResponse(chatId int64, response, variant string) {
 
 	// Send response
-	if err := k.sendMessage(chatId, response); err != nil {
+	if err := k.sendMessage(chatId, response, variant); err != nil {
 		log.Printf("Error sending message: %v", err)
 	}
 }
@@ -465,15 +601,23 @@ func (k *KiraBot) handleMessage(message *tgbotapi.Message) {
 		ok = true
 	}
 	if !ok {
-		log.Printf("Username: %v not in Allowed users", message)
-		k.sendMessage(message.Chat.ID, "Sorry leider musst du dich erst von Karl freischalten lassen. :)")
+		log.Printf("Username: %v not in Allowed users", message.From.UserName)
+		lang := k.languageForMessage(message.Chat.ID, message.From.UserName, message.From.LanguageCode)
+		k.sendMessage(message.Chat.ID, lang.Text.NotAllowed, "")
 		return
 	}
 
-	log.Printf("Received message from %s (%d): %s",
+	// The text is personal data, it is only stored encrypted
+	log.Printf("Received message %d from %s (%d), %d characters",
+		message.MessageID,
 		message.From.UserName,
 		message.From.ID,
-		message.Text)
+		utf8.RuneCountInString(message.Text))
+
+	if message.IsCommand() && message.Command() == "timezone" {
+		k.handleTimezoneCommand(message)
+		return
+	}
 
 	// Store the message
 	if err := k.storeMessage(message); err != nil {
@@ -493,8 +637,9 @@ func (k *KiraBot) sendTypingAction(chatID int64) error {
 	return nil
 }
 
-// sendMessage sends a text message
-func (k *KiraBot) sendMessage(chatID int64, text string) error {
+// sendMessage sends a text message, variant is the experiment/variant of the
+// prompt that produced it, empty for texts that weren't generated
+func (k *KiraBot) sendMessage(chatID int64, text, variant string) error {
 	msg := tgbotapi.NewMessage(chatID, text)
 	sentMsg, err := k.api.Send(msg)
 	if err != nil {
@@ -502,7 +647,7 @@ func (k *KiraBot) sendMessage(chatID int64, text string) error {
 	}
 
 	// Store the bot's response as well
-	if err := k.storeBotMessage(&sentMsg, text); err != nil {
+	if err := k.storeBotMessage(&sentMsg, text, variant); err != nil {
 		log.Printf("Error storing bot message: %v", err)
 	}
 
@@ -519,6 +664,10 @@ func (k *KiraBot) Shutdown() {
 	k.mu.Unlock()
 
 	close(k.stopChan)
+	k.promptCache.close()
+	if err := k.llm.Close(); err != nil {
+		log.Printf("Error closing LLM client: %v", err)
+	}
 	log.Println("Kira bot shutdown complete")
 }
 
@@ -563,17 +712,18 @@ func saveLastUpdateID(updateID int) {
 // storeMessage saves a user message to the chat file
 func (k *KiraBot) storeMessage(message *tgbotapi.Message) error {
 	chatMsg := ChatMessage{
-		MessageID:   message.MessageID,
-		Text:        message.Text,
-		SenderID:    message.From.ID,
-		SenderName:  fmt.Sprintf("%s %s", message.From.FirstName, message.From.LastName),
-		Username:    message.From.UserName,
-		Timestamp:   int64(message.Date),
-		Date:        time.Unix(int64(message.Date), 0).Format("2006-01-02 15:04:05"),
-		ChatID:      message.Chat.ID,
-		ChatTitle:   message.Chat.Title,
-		MessageType: "text",
-		IsBot:       message.From.IsBot,
+		MessageID:    message.MessageID,
+		Text:         message.Text,
+		SenderID:     message.From.ID,
+		SenderName:   fmt.Sprintf("%s %s", message.From.FirstName, message.From.LastName),
+		Username:     message.From.UserName,
+		Timestamp:    int64(message.Date),
+		Date:         time.Unix(int64(message.Date), 0).Format("2006-01-02 15:04:05"),
+		ChatID:       message.Chat.ID,
+		ChatTitle:    message.Chat.Title,
+		MessageType:  "text",
+		IsBot:        message.From.IsBot,
+		LanguageCode: message.From.LanguageCode,
 	}
 
 	// Handle different message types
@@ -603,14 +753,42 @@ func (k *KiraBot) storeMessage(message *tgbotapi.Message) error {
 		chatMsg.Text = fmt.Sprintf("[Contact: %s %s, %s]", message.Contact.FirstName, message.Contact.LastName, message.Contact.PhoneNumber)
 	}
 
+	// Check for acute distress first, this overrides everything else
+	chatMsg = k.checkCrisis(chatMsg)
+
+	// Run the moderation pipeline before anything is stored
+	chatMsg = k.moderateInbound(chatMsg)
+
+	// Without media processing in the user's plan Kira doesn't react to media
+	if chatMsg.MessageType != "text" && !k.plans.planFor(chatMsg.Username).MediaProcessing {
+		log.Printf("Media processing not in plan of %s, not responding to %s", chatMsg.Username, chatMsg.MessageType)
+		chatMsg.ShouldNotRespond = true
+	}
+
 	// Update in-memory chat data
 	k.updateChatInMemory(chatMsg)
 
+	// Answer in the language the user writes in
+	if !chatMsg.IsBot && chatMsg.MessageType == "text" && !chatMsg.DroppedFromContext {
+		k.detectChatLanguage(chatMsg.ChatID)
+	}
+
+	// The user answered, proactive messages start with the minimum silence again
+	if !chatMsg.IsBot {
+		k.resetProactiveBackoff(chatMsg.ChatID)
+		k.bursts.userWrote(chatMsg.ChatID)
+	}
+
+	// A shared location tells the user's time zone
+	if message.Location != nil && !chatMsg.IsBot {
+		k.timezoneFromLocation(chatMsg.ChatID, message.Location.Latitude, message.Location.Longitude)
+	}
+
 	return k.saveChatMessage(chatMsg)
 }
 
 // storeBotMessage saves the bot's response message
-func (k *KiraBot) storeBotMessage(message *tgbotapi.Message, text string) error {
+func (k *KiraBot) storeBotMessage(message *tgbotapi.Message, text, variant string) error {
 	chatMsg := ChatMessage{
 		MessageID:   message.MessageID,
 		Text:        text,
@@ -623,6 +801,8 @@ func (k *KiraBot) storeBotMessage(message *tgbotapi.Message, text string) error
 		ChatTitle:   message.Chat.Title,
 		MessageType: "text",
 		IsBot:       true,
+
+		PromptVariant: variant,
 	}
 
 	// Update in-memory chat data
@@ -635,13 +815,13 @@ func (k *KiraBot) storeBotMessage(message *tgbotapi.Message, text string) error
 func (k *KiraBot) saveChatMessage(msg ChatMessage) error {
 	// Create chats directory if it doesn't exist
 	chatsDir := "chats"
-	if err := os.MkdirAll(chatsDir, 0755); err != nil {
+	if err := os.MkdirAll(chatsDir, 0700); err != nil {
 		return fmt.Errorf("failed to create chats directory: %v", err)
 	}
 
 	// Create chat-specific directory
-	chatDir := filepath.Join(chatsDir, fmt.Sprintf("%d", msg.ChatID))
-	if err := os.MkdirAll(chatDir, 0755); err != nil {
+	chatDir := chatDirPath(msg.ChatID)
+	if err := os.MkdirAll(chatDir, 0700); err != nil {
 		return fmt.Errorf("failed to create chat directory: %v", err)
 	}
 
@@ -654,8 +834,18 @@ func (k *KiraBot) saveChatMessage(msg ChatMessage) error {
 		return fmt.Errorf("failed to marshal message: %v", err)
 	}
 
+	// Encrypt the line if enabled
+	cc, err := k.chatCipherFor(msg.ChatID)
+	if err != nil {
+		return fmt.Errorf("failed to get chat cipher: %v", err)
+	}
+	msgJSON, err = cc.seal(msgJSON)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt message: %v", err)
+	}
+
 	// Append to file (create if doesn't exist)
-	file, err := os.OpenFile(chatFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(chatFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to open chat file: %v", err)
 	}
@@ -721,13 +911,13 @@ func (k *KiraBot) loadLastHelperScannedMsg(chatID int64, filePath string) error
 func (k *KiraBot) saveLastHelperScannedMsg(chatID int64, msgID int64) error {
 	// Create chats directory if it doesn't exist
 	chatsDir := "chats"
-	if err := os.MkdirAll(chatsDir, 0755); err != nil {
+	if err := os.MkdirAll(chatsDir, 0700); err != nil {
 		return fmt.Errorf("failed to create chats directory: %v", err)
 	}
 
 	// Create chat-specific directory
 	chatDir := filepath.Join(chatsDir, fmt.Sprintf("%d", chatID))
-	if err := os.MkdirAll(chatDir, 0755); err != nil {
+	if err := os.MkdirAll(chatDir, 0700); err != nil {
 		return fmt.Errorf("failed to create chat directory: %v", err)
 	}
 
@@ -736,7 +926,7 @@ func (k *KiraBot) saveLastHelperScannedMsg(chatID int64, msgID int64) error {
 
 	// Write message ID to file
 	data := fmt.Sprintf("%d", msgID)
-	if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+	if err := os.WriteFile(filePath, []byte(data), 0600); err != nil {
 		return fmt.Errorf("failed to write last scanned msg file: %v", err)
 	}
 
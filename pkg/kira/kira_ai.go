@@ -2,6 +2,8 @@
 package kira
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"math/rand/v2"
 	"regexp"
@@ -43,29 +45,75 @@ func (k *KiraBot) AIRun() error {
 
 			log.Printf("After scanning new")
 
-			shouldRespond, shouldProvideExtraStory := k.shouldRespondToMessage(lastMsg, lastMessages)
+			// A message queued while the limit was reached is answered first
+			if chat.PendingReplyMsgID != 0 {
+				k.answerPendingReply(chat, lastMessages)
+				continue
+			}
+
+			shouldRespond, shouldProvideExtraStory := k.shouldRespondToMessage(chat, k.nowFor(chat), lastMsg, lastMessages)
+
+			// Ask how an event of the user went or greet on a special day, if there is nothing else to answer
+			var followUp *Event
+			var greet *greeting
+			var occasion string
+			if !shouldRespond {
+				lang := k.languageFor(chat)
+				if followUp = k.followUpDue(chat, lastMsg, k.nowFor(chat)); followUp != nil {
+					occasion = fmt.Sprintf(lang.Prompt.FollowUp, followUp.What, followUp.Date)
+				} else if greet = k.greetingDue(chat, lastMsg, k.nowFor(chat)); greet != nil {
+					occasion = greet.prompt(lang)
+				}
+				shouldRespond = occasion != ""
+			}
+			proactive := shouldProvideExtraStory || occasion != ""
+
+			if shouldRespond && proactive && !k.planForChat(chat).ProactiveMessages {
+				log.Printf("Proactive messages not in plan for chat %d", chat.ChatId)
+				shouldRespond = false
+			}
+
+			if shouldRespond && proactive && k.breaker.isOpen() {
+				log.Printf("Provider is down, pausing proactive message for chat %d", chat.ChatId)
+				shouldRespond = false
+			}
 
 			if shouldRespond {
 
+				if limitErr := k.checkReplyLimits(chat); limitErr != nil {
+					if proactive {
+						// Nobody is waiting for an answer, just don't reach out
+						log.Printf("Skipping proactive message for chat %d: %v", chat.ChatId, limitErr)
+						continue
+					}
+					k.handleLimitReached(chat, lastMsg, limitErr)
+					continue
+				}
+
 				err := k.sendTypingAction(chat.ChatId)
 				if err != nil {
 					log.Println("Send Typing Action failed, skipping response generation")
 					continue
 				}
-				response := k.generateAIResponse(lastMessages, chat, shouldProvideExtraStory)
-				if response == "" || response == "\"\"\n" || response == "\"\"" {
-					log.Println("Marking message as not respond to.")
-					k.markLastMessageAsShouldNotRespondTo(chat, lastMsg)
-					continue
+				k.bursts.beginReply(chat.ChatId)
+				result := k.generateAIResponse(lastMessages, chat, shouldProvideExtraStory, occasion)
+				sent, done := k.handleTalkResult(chat, lastMsg, result, proactive)
+				if done && shouldProvideExtraStory && occasion == "" {
+					// Silence and blocks count as attempts too, so the model isn't
+					// asked on every run. Follow-ups and greetings don't wait for
+					// the back-off or the weekly cap, so they don't count.
+					k.recordProactive(chat.ChatId, lastMsg.MessageID, sent)
 				}
-
-				if response == "TIMEOUT" {
-					continue
+				if done {
+					// Sent or decided against, an interrupted greeting is tried again
+					if followUp != nil {
+						k.markFollowedUp(chat.ChatId, *followUp)
+					}
+					if greet != nil {
+						k.markGreeted(chat.ChatId, *greet)
+					}
 				}
-
-				// Send the response
-				//k.SendResponse(chat.ChatId, response)
-				k.sendResponseWithSplitting(chat.ChatId, response)
+				k.bursts.endReply(chat.ChatId)
 			} else {
 				log.Printf("Skipping response for chat")
 			}
@@ -76,8 +124,114 @@ func (k *KiraBot) AIRun() error {
 	return nil
 }
 
-func (k *KiraBot) sendResponseWithSplitting(chatId int64, response string) {
-	messages := k.splitMessage(response)
+// answerPendingReply answers a message that was queued while the limit was
+// reached, as soon as the limit has reset and Kira is awake.
+func (k *KiraBot) answerPendingReply(chat CompleteChat, lastMessages []ChatMessage) {
+	if !k.personaFor(chat).isAwake(k.nowFor(chat)) {
+		return
+	}
+	if limitErr := k.checkReplyLimits(chat); limitErr != nil {
+		if chat.LimitNoticeDate != k.todayFor(chat) {
+			// The notice was interrupted by the user, send it again
+			k.handleLimitReached(chat, lastMessages[len(lastMessages)-1], limitErr)
+		}
+		return
+	}
+
+	// The user may have written more in the meantime, the latest user message is answered
+	log.Printf("Answering queued message %d of chat %d", chat.PendingReplyMsgID, chat.ChatId)
+
+	if err := k.sendTypingAction(chat.ChatId); err != nil {
+		log.Println("Send Typing Action failed, skipping queued response")
+		return
+	}
+	k.bursts.beginReply(chat.ChatId)
+	defer k.bursts.endReply(chat.ChatId)
+
+	result := k.generateAIResponse(lastMessages, chat, false, "")
+	switch result.Outcome {
+	case TalkLimitReached, TalkTimeout, TalkProviderError:
+		// Keep the message queued and try again in the next run
+		log.Printf("Queued message of chat %d not answered (%s): %v", chat.ChatId, result.Outcome, result.Err)
+		return
+	}
+
+	k.clearPendingReply(chat.ChatId)
+	if result.Outcome != TalkReply {
+		log.Printf("No response for queued message (%s).", result.Outcome)
+		return
+	}
+
+	k.deliverReply(chat.ChatId, result)
+}
+
+// handleTalkResult acts on the outcome of a reply generation. It returns
+// whether the reply was sent and whether the attempt is done: the reply was
+// sent, or the persona stayed silent or was blocked. A reply the user interrupted before anything was
+// sent, or one that failed, is generated again later.
+func (k *KiraBot) handleTalkResult(chat CompleteChat, lastMsg ChatMessage, result TalkResult, proactive bool) (sent, done bool) {
+
+	switch result.Outcome {
+	case TalkReply:
+		var interrupted bool
+		sent, interrupted = k.deliverReply(chat.ChatId, result)
+		// A reply withheld by moderation is done as well
+		done = sent || !interrupted
+	case TalkSilence:
+		done = true
+		log.Println("Marking message as not respond to.")
+		k.markLastMessageAsShouldNotRespondTo(chat, lastMsg)
+	case TalkBlocked:
+		done = true
+		// Even the fallbacks were blocked, don't try again on every run
+		log.Printf("Reply for chat %d blocked: %v", chat.ChatId, result.Err)
+		k.markLastMessageAsShouldNotRespondTo(chat, lastMsg)
+	case TalkLimitReached:
+		var limitErr *limitError
+		if proactive {
+			// Nobody is waiting for an answer, just don't reach out
+			log.Printf("Skipping proactive message for chat %d: %v", chat.ChatId, result.Err)
+		} else if errors.As(result.Err, &limitErr) {
+			k.handleLimitReached(chat, lastMsg, limitErr)
+		}
+	case TalkTimeout, TalkProviderError:
+		// Try again in the next run
+		log.Printf("Reply for chat %d failed (%s): %v", chat.ChatId, result.Outcome, result.Err)
+	default:
+		log.Printf("Unknown talk outcome %s for chat %d", result.Outcome, chat.ChatId)
+	}
+
+	return sent, done
+}
+
+// deliverReply runs the outbound moderation on a generated reply and sends it.
+// It reports whether anything was sent and whether the user interrupted the
+// reply.
+func (k *KiraBot) deliverReply(chatId int64, result TalkResult) (sent, interrupted bool) {
+	response, ok := k.moderateOutbound(chatId, result.Text)
+	if !ok {
+		log.Printf("Reply for chat %d withheld by moderation", chatId)
+		return false, false
+	}
+
+	// She started typing while the model was still writing
+	var typed time.Duration
+	if !result.TypingSince.IsZero() {
+		typed = time.Since(result.TypingSince)
+	}
+	n, complete := k.sendResponseWithSplitting(chatId, response, result.Variant, typed)
+	if !complete {
+		log.Printf("Reply for chat %d interrupted by a new message after %d messages", chatId, n)
+	}
+	return n > 0, !complete
+}
+
+// sendResponseWithSplitting types and sends a reply and returns the number of
+// messages sent. typed is how long the first message was already typed.
+// complete is false if the user wrote while it was typed, the rest of the
+// reply is dropped then.
+func (k *KiraBot) sendResponseWithSplitting(chatId int64, response, variant string, typed time.Duration) (sent int, complete bool) {
+	messages := k.splitMessage(response, k.personaForChatID(chatId).EmojiSplitChance)
 
 	for _, msg := range messages {
 		// Send typing action to indicate bot is "typing"
@@ -87,16 +241,20 @@ func (k *KiraBot) sendResponseWithSplitting(chatId int64, response string) {
 		charDelay := time.Duration(120+rand.IntN(50)) * time.Millisecond // Use IntN from math/rand/v2
 
 		// Simulate typing by waiting per character
-		for range []rune(msg) {
-			time.Sleep(charDelay)
+		delay := max(charDelay*time.Duration(utf8.RuneCountInString(msg))-typed, 0)
+		typed = 0
+		if !k.bursts.sleep(chatId, delay) {
+			return sent, false
 		}
 
 		// Send the message
-		k.SendResponse(chatId, msg)
+		k.SendResponse(chatId, msg, variant)
+		sent++
 	}
+	return sent, true
 }
 
-func (k *KiraBot) splitMessage(message string) []string {
+func (k *KiraBot) splitMessage(message string, emojiSplitChance float64) []string {
 	// Define what we consider "long" - adjust this threshold as needed
 	const longMessageThreshold = 150
 
@@ -105,7 +263,7 @@ func (k *KiraBot) splitMessage(message string) []string {
 	shouldSplitLong := isLong && rand.Float64() < 0.5
 
 	// Check if message ends with smiley and should be split
-	shouldSplitSmiley := k.shouldSplitSmiley(message)
+	shouldSplitSmiley := k.shouldSplitSmiley(message, emojiSplitChance)
 
 	// If no splitting needed, return original message
 	if !shouldSplitLong && !shouldSplitSmiley {
@@ -136,13 +294,13 @@ func (k *KiraBot) splitMessage(message string) []string {
 	return messages
 }
 
-func (k *KiraBot) shouldSplitSmiley(message string) bool {
+func (k *KiraBot) shouldSplitSmiley(message string, chance float64) bool {
 	if !k.endsWithSmiley(message) {
 		return false
 	}
 
-	// Random choice to split smiley
-	return rand.Float64() < 0.5
+	// Random choice to split smiley, the chance is part of the persona's style
+	return rand.Float64() < chance
 }
 
 func (k *KiraBot) endsWithSmiley(message string) bool {
@@ -192,59 +350,66 @@ func (k *KiraBot) markLastMessageAsShouldNotRespondTo(chat CompleteChat, lastMsg
 }
 func (k *KiraBot) generateInfoHelper(messages []ChatMessage, completeChat CompleteChat, lastMSGID int) {
 
-	updatedChat := k.chats[completeChat.ChatId]
-	oldInfo := updatedChat.Infos
+	oldInfo := completeChat.Infos
 
+	// The LLM calls run without holding the lock, they record usage on the chat
 	newInfo, err := k.callGeminiHelper(completeChat.Infos, messages, completeChat)
+	if errors.Is(err, ErrBlocked) {
+		log.Printf("Helper call blocked: %v", err)
+
+		sanitizer := k.moderation.sanitizer()
+
+		// Clean messages before processing
+		cleanedMessages := sanitizer.CleanChatMessages(messages)
+		// Clean the form
+		cleanedForm := sanitizer.CleanKiraHelperForm(completeChat.Infos)
+		// Now use cleaned data with Gemini
+		newInfo, err = k.callGeminiHelper(cleanedForm, cleanedMessages, completeChat)
+		if err != nil {
+			log.Printf("Error after cleaning: %v", err)
+		}
+	}
+	if err == nil {
+		// A form that can't be repaired is rejected, the old form stays
+		newInfo, err = checkHelperForm(completeChat.ChatId, k.personaFor(completeChat).Seed, oldInfo, newInfo)
+	}
+
 	k.mu.Lock()
 	defer k.mu.Unlock()
-	if err != nil {
-		if strings.Contains(err.Error(), "blocked:") {
-			log.Printf("blocked:")
-
-			sanitizer := NewSimpleSanitizer()
-
-			// Clean messages before processing
-			cleanedMessages := sanitizer.CleanChatMessages(messages)
-			// Clean the form
-			cleanedForm := sanitizer.CleanKiraHelperForm(completeChat.Infos)
-			// Now use cleaned data with Gemini
-			newInfo, err = k.callGeminiHelper(cleanedForm, cleanedMessages, completeChat)
-			if err != nil {
-				log.Printf("Error after cleaning: %v", err)
 
-			}
-
-		} else {
-
-			if strings.Contains(err.Error(), "limit") {
-
-				log.Printf("Error: %v", err)
-				updatedChat.Infos = oldInfo
-				// Dont update last msg scanned
-				k.chats[completeChat.ChatId] = updatedChat
-				k.saveChatInfo(completeChat.ChatId, oldInfo)
-				if saveErr := k.saveLastHelperScannedMsg(completeChat.ChatId, int64(lastMSGID)); saveErr != nil {
-					log.Printf("Error saving last scanned msg after error: %v", saveErr)
-				}
-				return
+	updatedChat := k.chats[completeChat.ChatId]
 
-			}
+	if err != nil {
+		// Out of budget or the provider is down, scan again later
+		if errors.Is(err, ErrQuota) || isOutage(err) || errors.Is(err, ErrCircuitOpen) {
 
 			log.Printf("Error: %v", err)
 			updatedChat.Infos = oldInfo
-			updatedChat.LastHelperScannedMsg = int64(lastMSGID)
+			// Dont update last msg scanned
 			k.chats[completeChat.ChatId] = updatedChat
 			k.saveChatInfo(completeChat.ChatId, oldInfo)
 			if saveErr := k.saveLastHelperScannedMsg(completeChat.ChatId, int64(lastMSGID)); saveErr != nil {
 				log.Printf("Error saving last scanned msg after error: %v", saveErr)
 			}
 			return
+
 		}
+
+		log.Printf("Error: %v", err)
+		updatedChat.Infos = oldInfo
+		updatedChat.LastHelperScannedMsg = int64(lastMSGID)
+		k.chats[completeChat.ChatId] = updatedChat
+		k.saveChatInfo(completeChat.ChatId, oldInfo)
+		if saveErr := k.saveLastHelperScannedMsg(completeChat.ChatId, int64(lastMSGID)); saveErr != nil {
+			log.Printf("Error saving last scanned msg after error: %v", saveErr)
+		}
+		return
 	}
 
 	updatedChat.Infos = newInfo
 	updatedChat.LastHelperScannedMsg = int64(lastMSGID)
+	// The cached talk prompt contains the old form
+	k.promptCache.invalidate(completeChat.ChatId)
 	k.chats[completeChat.ChatId] = updatedChat
 	k.saveChatInfo(completeChat.ChatId, newInfo)
 	if err := k.saveLastHelperScannedMsg(completeChat.ChatId, int64(lastMSGID)); err != nil {
@@ -253,45 +418,56 @@ func (k *KiraBot) generateInfoHelper(messages []ChatMessage, completeChat Comple
 
 }
 
-func (k *KiraBot) generateAIResponse(messages []ChatMessage, completeChat CompleteChat, shouldProvideExtraStory bool) string {
+// generateAIResponse generates the next reply and checks it against the
+// guardrails. Only a produced reply counts against the daily message limit,
+// fallback retries, regenerations and silence are free.
+func (k *KiraBot) generateAIResponse(messages []ChatMessage, completeChat CompleteChat, shouldProvideExtraStory bool, occasion string) TalkResult {
+	if limitErr := k.checkReplyLimits(completeChat); limitErr != nil {
+		log.Printf("Limit reached for chat %d (%d/%d messages): %v",
+			completeChat.ChatId, completeChat.DailyMessageCount, completeChat.DailyLimit, limitErr)
+		return TalkResult{Outcome: TalkLimitReached, Err: limitErr}
+	}
 
-	response, err := k.callGeminiTalk(completeChat.Infos, messages, shouldProvideExtraStory, completeChat)
-	if err != nil {
-		if strings.Contains(err.Error(), "block:") {
-			log.Printf("Block encountered, trying fallback with cleaning infos")
-
-			sanitizer := NewSimpleSanitizer()
-
-			// Clean messages before processing
-			cleanedMessages := sanitizer.CleanChatMessages(messages)
-			cleanedForm := sanitizer.CleanKiraHelperForm(completeChat.Infos)
-
-			response, err = k.callGeminiTalk(cleanedForm, cleanedMessages, shouldProvideExtraStory, completeChat)
-			if err != nil {
-				if strings.Contains(err.Error(), "block:") {
-					log.Printf("block: encountered again, trying complete fallback")
-					// Create empty KiraHelperForm instead of using zero value
-					emptyForm := createEmptyKiraHelperForm()
-
-					// Complete new with story - use empty messages and force story mode
-					response, err = k.callGeminiTalk(emptyForm, []ChatMessage{}, true, completeChat)
-					if err != nil {
-						log.Printf("Error new with story: %v", err)
-						return ""
-					}
-					return response
-				}
-				log.Printf("Error in fallback callGeminiTalk: %v", err)
-				return ""
-			}
-			return response
+	result := k.generateAIResponseWithFallback(messages, completeChat, shouldProvideExtraStory, occasion)
+	result = k.guardReply(completeChat.ChatId, result, func() TalkResult {
+		return k.generateAIResponseWithFallback(messages, completeChat, shouldProvideExtraStory, occasion)
+	})
+	if result.Outcome == TalkReply {
+		if err := k.incrementDailyCounter(completeChat.ChatId); err != nil {
+			log.Printf("Error saving daily counter: %v", err)
 		}
+	}
+
+	return result
+}
 
-		log.Printf("Error callGeminiTalk: %v", err)
-		return ""
+// generateAIResponseWithFallback calls the model and retries with cleaned data if the request was blocked
+func (k *KiraBot) generateAIResponseWithFallback(messages []ChatMessage, completeChat CompleteChat, shouldProvideExtraStory bool, occasion string) TalkResult {
+
+	result := k.callGeminiTalk(completeChat.Infos, messages, shouldProvideExtraStory, occasion, completeChat)
+	if result.Outcome != TalkBlocked {
+		return result
 	}
 
-	return response
+	log.Printf("Block encountered, trying fallback with cleaning infos: %v", result.Err)
+
+	sanitizer := k.moderation.sanitizer()
+
+	// Clean messages before processing
+	cleanedMessages := sanitizer.CleanChatMessages(messages)
+	cleanedForm := sanitizer.CleanKiraHelperForm(completeChat.Infos)
+
+	result = k.callGeminiTalk(cleanedForm, cleanedMessages, shouldProvideExtraStory, occasion, completeChat)
+	if result.Outcome != TalkBlocked {
+		return result
+	}
+
+	log.Printf("Block encountered again, trying complete fallback: %v", result.Err)
+	// Create empty KiraHelperForm instead of using zero value
+	emptyForm := createEmptyKiraHelperForm()
+
+	// Complete new with story - use empty messages and force story mode
+	return k.callGeminiTalk(emptyForm, []ChatMessage{}, true, occasion, completeChat)
 }
 
 // truncateText truncates text to a maximum length for logging
@@ -0,0 +1,171 @@
+package kira
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LanguagePack holds the prompts and user-facing texts of one language. The
+// persona of a language lives in personas/<code>/.
+type LanguagePack struct {
+	Code         string `yaml:"-"` // file name without .yaml, e.g. "de"
+	HelperPrompt string `yaml:"helper_prompt"`
+
+	Prompt struct {
+		UserInfo       string `yaml:"user_info"`
+		PersonaInfo    string `yaml:"persona_info"` // %s is the persona's name
+		Messages       string `yaml:"messages"`
+		Events         string `yaml:"events"`
+		FollowUp       string `yaml:"follow_up"` // %s is the event, %s its date
+		Birthday       string `yaml:"birthday"`
+		PersonBirthday string `yaml:"person_birthday"` // %s is the person's name
+		Anniversary    string `yaml:"anniversary"`     // %s is the day of the first chat
+		Now            string `yaml:"now"`             // %s is the current time
+		ExtraStory     string `yaml:"extra_story"`
+	} `yaml:"prompt"`
+
+	Text struct {
+		NotAllowed   string   `yaml:"not_allowed"`
+		Refusal      string   `yaml:"refusal"`
+		LimitNotices []string `yaml:"limit_notices"` // %s is the reset hint
+		BackToday    string   `yaml:"back_today"`    // %d is the hour
+		BackTomorrow string   `yaml:"back_tomorrow"` // %d is the hour
+		BackOn       string   `yaml:"back_on"`       // %s is the date
+		DateFormat   string   `yaml:"date_format"`
+
+		TimezoneSet     string `yaml:"timezone_set"`     // %s is the zone
+		TimezoneCurrent string `yaml:"timezone_current"` // %s is the zone, %s the local time
+		TimezoneInvalid string `yaml:"timezone_invalid"`
+	} `yaml:"text"`
+
+	DetectWords []string `yaml:"detect_words"` // common words of the language, see detectLanguage
+
+	detectWords map[string]bool
+}
+
+// languageSet holds the language packs of the deployment
+type languageSet struct {
+	packs       map[string]*LanguagePack
+	defaultCode string
+}
+
+// loadLanguages loads every .yaml file in dir, the default language must be one of them
+func loadLanguages(dir, defaultCode string) (*languageSet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list language packs: %w", err)
+	}
+
+	set := &languageSet{packs: make(map[string]*LanguagePack), defaultCode: defaultCode}
+	for _, path := range paths {
+		pack, err := loadLanguagePack(path)
+		if err != nil {
+			return nil, err
+		}
+		set.packs[pack.Code] = pack
+	}
+	log.Printf("Loaded %d language packs from %s", len(set.packs), dir)
+
+	if _, ok := set.packs[defaultCode]; !ok {
+		return nil, fmt.Errorf("language pack for the default language %q not found in %s", defaultCode, dir)
+	}
+	return set, nil
+}
+
+func loadLanguagePack(path string) (*LanguagePack, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read language pack: %w", err)
+	}
+
+	pack := &LanguagePack{Code: strings.TrimSuffix(filepath.Base(path), ".yaml")}
+	if err := yaml.Unmarshal(data, pack); err != nil {
+		return nil, fmt.Errorf("failed to decode language pack %s: %w", path, err)
+	}
+
+	for name, value := range map[string]string{
+		"helper_prompt":          pack.HelperPrompt,
+		"prompt.user_info":       pack.Prompt.UserInfo,
+		"prompt.persona_info":    pack.Prompt.PersonaInfo,
+		"prompt.messages":        pack.Prompt.Messages,
+		"prompt.events":          pack.Prompt.Events,
+		"prompt.follow_up":       pack.Prompt.FollowUp,
+		"prompt.birthday":        pack.Prompt.Birthday,
+		"prompt.person_birthday": pack.Prompt.PersonBirthday,
+		"prompt.anniversary":     pack.Prompt.Anniversary,
+		"prompt.now":             pack.Prompt.Now,
+		"prompt.extra_story":     pack.Prompt.ExtraStory,
+		"text.not_allowed":       pack.Text.NotAllowed,
+		"text.refusal":           pack.Text.Refusal,
+		"text.back_today":        pack.Text.BackToday,
+		"text.back_tomorrow":     pack.Text.BackTomorrow,
+		"text.back_on":           pack.Text.BackOn,
+		"text.date_format":       pack.Text.DateFormat,
+		"text.timezone_set":      pack.Text.TimezoneSet,
+		"text.timezone_current":  pack.Text.TimezoneCurrent,
+		"text.timezone_invalid":  pack.Text.TimezoneInvalid,
+	} {
+		if strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("language pack %s: %s is missing", path, name)
+		}
+	}
+	if len(pack.Text.LimitNotices) == 0 {
+		return nil, fmt.Errorf("language pack %s: text.limit_notices is missing", path)
+	}
+
+	pack.detectWords = make(map[string]bool, len(pack.DetectWords))
+	for _, word := range pack.DetectWords {
+		pack.detectWords[strings.ToLower(word)] = true
+	}
+	return pack, nil
+}
+
+// get returns a language pack, the default one if code is empty or unknown
+func (s *languageSet) get(code string) *LanguagePack {
+	if pack, ok := s.packs[code]; ok {
+		return pack
+	}
+	return s.packs[s.defaultCode]
+}
+
+// resolve picks the language of a new chat: the one assigned in the plans
+// file, then the user's Telegram language if there is a pack for it, then
+// the default
+func (s *languageSet) resolve(assigned, telegramCode string) string {
+	if _, ok := s.packs[assigned]; ok {
+		return assigned
+	}
+	if assigned != "" {
+		log.Printf("Unknown language %q assigned, ignoring it", assigned)
+	}
+	// Telegram sends IETF tags like "en-US"
+	base, _, _ := strings.Cut(strings.ToLower(telegramCode), "-")
+	if _, ok := s.packs[base]; ok {
+		return base
+	}
+	return s.defaultCode
+}
+
+// languageFor returns the language pack of a chat
+func (k *KiraBot) languageFor(chat CompleteChat) *LanguagePack {
+	return k.languages.get(chat.Language)
+}
+
+// languageForMessage returns the language pack for answering a message. For
+// a chat that doesn't exist yet it is the language the chat will get.
+// It takes k.mu.
+func (k *KiraBot) languageForMessage(chatID int64, username, telegramCode string) *LanguagePack {
+	k.mu.Lock()
+	chat, exists := k.chats[chatID]
+	k.mu.Unlock()
+
+	if exists {
+		return k.languages.get(chat.Language)
+	}
+	return k.languages.get(k.languages.resolve(k.plans.languageFor(username), telegramCode))
+}
@@ -0,0 +1,94 @@
+package kira
+
+import (
+	"log"
+	"strings"
+	"unicode"
+
+	"gitea.karlbreuer.com/karl1b/kira/pkg/settings"
+)
+
+const (
+	detectMessages = 5 // last user messages the language is detected from
+	minDetectHits  = 4 // words of a language needed before switching to it
+)
+
+// detectLanguage guesses the language of texts by counting the detect words
+// of every pack. It only returns a language that is well ahead of all others,
+// a short "ok 👍" or a mix of languages gives "".
+func detectLanguage(texts []string, languages *languageSet) string {
+	hits := make(map[string]int)
+	for _, text := range texts {
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && r != '\''
+		})
+		for code, pack := range languages.packs {
+			for _, word := range words {
+				if pack.detectWords[word] {
+					hits[code]++
+				}
+			}
+		}
+	}
+
+	best := ""
+	for code, n := range hits {
+		if best == "" || n > hits[best] {
+			best = code
+		}
+	}
+	if best == "" || hits[best] < minDetectHits {
+		return ""
+	}
+	for code, n := range hits {
+		if code != best && hits[best] < 2*n {
+			return ""
+		}
+	}
+	return best
+}
+
+// detectChatLanguage switches a chat to the language the user writes in.
+// A language assigned in the plans file wins over the detected one. The new
+// language is stored with the chat, the persona follows it if there is a
+// version of it in that language. It takes k.mu.
+func (k *KiraBot) detectChatLanguage(chatID int64) {
+	if !settings.Settings.DetectLanguage || len(k.languages.packs) < 2 {
+		return
+	}
+
+	k.mu.Lock()
+	chat, exists := k.chats[chatID]
+	k.mu.Unlock()
+	if !exists || k.plans.languageFor(chatUsername(chat)) != "" {
+		return
+	}
+
+	var texts []string
+	for _, msg := range k.GetLastMessages(chat.Chats, 3*detectMessages) {
+		if msg.IsBot || msg.MessageType != "text" || msg.DroppedFromContext {
+			continue
+		}
+		texts = append(texts, msg.Text)
+	}
+	if len(texts) > detectMessages {
+		texts = texts[len(texts)-detectMessages:]
+	}
+
+	detected := detectLanguage(texts, k.languages)
+	current := k.languages.get(chat.Language).Code
+	if detected == "" || detected == current {
+		return
+	}
+
+	k.mu.Lock()
+	chat = k.chats[chatID]
+	chat.Language = detected
+	k.chats[chatID] = chat
+	k.mu.Unlock()
+
+	log.Printf("Chat %d switched language from %s to %s", chatID, current, detected)
+	if err := k.saveChatState(chat); err != nil {
+		log.Printf("Error saving chat state: %v", err)
+	}
+}
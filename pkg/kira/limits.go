@@ -1,17 +1,48 @@
 package kira
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
 	"time"
 )
 
 const (
-	dailyLimit = 30 // Here is the daily chat limit per chat! :-)
+	dailyLimit = 30 // Default daily chat limit per chat, plans in plans.json override it
 )
 
-// checkDailyLimit checks if the chat is within daily message limits
+// limitError is returned when a chat or the deployment is out of messages or tokens
+type limitError struct {
+	reason  string
+	resetAt time.Time // when the limit resets
+}
+
+func (e *limitError) Error() string {
+	return "limit reached: " + e.reason
+}
+
+// nextMidnight returns the start of the next day
+func nextMidnight(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+}
+
+// nextMonth returns the start of the next month
+func nextMonth(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+}
+
+// todayFor returns the current day of a chat. The daily limit resets at
+// midnight in the chat's time zone.
+func (k *KiraBot) todayFor(chat CompleteChat) string {
+	return k.nowFor(chat).Format("2006-01-02")
+}
+
+// checkDailyLimit checks if the chat is within the daily message limit of the user's plan
 func (k *KiraBot) checkDailyLimit(chat CompleteChat) bool {
-	today := time.Now().Format("2006-01-02")
+	today := k.todayFor(chat)
 
 	// Reset counter if it's a new day
 	if chat.LastMessageDate != today {
@@ -19,20 +50,32 @@ func (k *KiraBot) checkDailyLimit(chat CompleteChat) bool {
 	}
 
 	// Check if under limit
-	limit := chat.DailyLimit
-	if limit == 0 {
-		limit = dailyLimit
-	}
+	limit := k.planForChat(chat).DailyMessages
 
 	return chat.DailyMessageCount < limit
 }
 
+// checkReplyLimits checks the daily message limit and the conversation token budget
+func (k *KiraBot) checkReplyLimits(chat CompleteChat) *limitError {
+	if !k.checkDailyLimit(chat) {
+		return &limitError{
+			reason:  fmt.Sprintf("daily message limit of %d", k.planForChat(chat).DailyMessages),
+			resetAt: nextMidnight(k.nowFor(chat)),
+		}
+	}
+	if err := k.checkTokenBudget(chat.ChatId, usageTalk); err != nil {
+		return err
+	}
+	return nil
+}
+
 // incrementDailyCounter increments the daily message counter
 func (k *KiraBot) incrementDailyCounter(chatID int64) error {
 	k.mu.Lock()
+	plan := k.plans.planFor(chatUsername(k.chats[chatID]))
 
 	chat := k.chats[chatID]
-	today := time.Now().Format("2006-01-02")
+	today := k.todayFor(chat)
 
 	if chat.LastMessageDate != today {
 		// New day, reset counter
@@ -44,15 +87,204 @@ func (k *KiraBot) incrementDailyCounter(chatID int64) error {
 		chat.DailyMessageCount++
 	}
 
-	// Set default limit if not set
-	if chat.DailyLimit == 0 {
-		chat.DailyLimit = dailyLimit
-	}
+	// Keep the limit of the current plan for logging
+	chat.DailyLimit = plan.DailyMessages
 
 	k.chats[chatID] = chat
 
 	log.Printf("Daily message count for chat %d: %d/%d", chatID, chat.DailyMessageCount, chat.DailyLimit)
 	k.mu.Unlock()
 	// Save to file
-	return k.saveChatInfo(chatID, chat.Infos)
+	return k.saveChatState(chat)
+}
+
+// handleLimitReached queues the user's message for after the reset and tells
+// the user once per day, in character, when Kira will answer again.
+func (k *KiraBot) handleLimitReached(chat CompleteChat, lastMsg ChatMessage, limitErr *limitError) {
+	today := k.todayFor(chat)
+
+	k.mu.Lock()
+	current := k.chats[chat.ChatId]
+	sendNotice := current.LimitNoticeDate != today
+	if !lastMsg.IsBot && current.PendingReplyMsgID == 0 {
+		current.PendingReplyMsgID = lastMsg.MessageID
+		log.Printf("Queued message %d of chat %d until %s", lastMsg.MessageID, chat.ChatId, limitErr.resetAt.Format("2006-01-02 15:04"))
+	}
+	k.chats[chat.ChatId] = current
+	k.mu.Unlock()
+
+	if err := k.saveChatState(current); err != nil {
+		log.Printf("Error saving chat state: %v", err)
+	}
+
+	if !sendNotice {
+		log.Printf("Limit notice already sent today for chat %d (%v)", chat.ChatId, limitErr)
+		return
+	}
+
+	log.Printf("Sending limit notice to chat %d (%v)", chat.ChatId, limitErr)
+	lang := k.languageFor(chat)
+	notices := lang.Text.LimitNotices
+	now := k.nowFor(chat)
+	notice := fmt.Sprintf(notices[rand.IntN(len(notices))], resetHint(lang, now, limitErr.resetAt.In(now.Location()), k.personaFor(chat).WakingHours.Wake))
+	if sent, _ := k.sendResponseWithSplitting(chat.ChatId, notice, "", 0); sent == 0 {
+		// The user interrupted it, the next run sends it again
+		return
+	}
+
+	k.mu.Lock()
+	current = k.chats[chat.ChatId]
+	current.LimitNoticeDate = today
+	k.chats[chat.ChatId] = current
+	k.mu.Unlock()
+
+	if err := k.saveChatState(current); err != nil {
+		log.Printf("Error saving chat state: %v", err)
+	}
+}
+
+// resetHint describes when the persona is back, taking the waking hours into
+// account. now and resetAt are in the chat's time zone.
+func resetHint(lang *LanguagePack, now, resetAt time.Time, wakeHour int) string {
+	back := resetAt
+	if back.Hour() < wakeHour {
+		back = time.Date(back.Year(), back.Month(), back.Day(), wakeHour, 0, 0, 0, back.Location())
+	}
+
+	switch {
+	case back.Format("2006-01-02") == now.Format("2006-01-02"):
+		return fmt.Sprintf(lang.Text.BackToday, back.Hour())
+	case back.Format("2006-01-02") == now.AddDate(0, 0, 1).Format("2006-01-02"):
+		return fmt.Sprintf(lang.Text.BackTomorrow, back.Hour())
+	default:
+		return fmt.Sprintf(lang.Text.BackOn, back.Format(lang.Text.DateFormat))
+	}
+}
+
+// clearPendingReply removes the queued message once it has been answered
+func (k *KiraBot) clearPendingReply(chatID int64) {
+	k.mu.Lock()
+	chat := k.chats[chatID]
+	chat.PendingReplyMsgID = 0
+	k.chats[chatID] = chat
+	k.mu.Unlock()
+
+	if err := k.saveChatState(chat); err != nil {
+		log.Printf("Error saving chat state: %v", err)
+	}
+}
+
+// chatState is the part of CompleteChat that is stored in state.json
+type chatState struct {
+	DailyMessageCount int    `json:"daily_message_count"`
+	LastMessageDate   string `json:"last_message_date"`
+	LimitNoticeDate   string `json:"limit_notice_date"`
+	PendingReplyMsgID int    `json:"pending_reply_msg_id"`
+	CrisisFlaggedAt   int64  `json:"crisis_flagged_at,omitempty"`
+	Persona           string `json:"persona,omitempty"`
+	Language          string `json:"language,omitempty"`
+	Timezone          string `json:"timezone,omitempty"`
+
+	ProactiveSent        []int64 `json:"proactive_sent,omitempty"`
+	LastProactiveAttempt int64   `json:"last_proactive_attempt,omitempty"`
+	UnansweredProactive  int     `json:"unanswered_proactive,omitempty"`
+
+	FollowedUpEvents []string `json:"followed_up_events,omitempty"`
+	Greeted          []string `json:"greeted,omitempty"`
+}
+
+// loadChatState loads the counters and queue of a chat from state.json
+func (k *KiraBot) loadChatState(chatID int64, filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	cc, err := k.chatCipherFor(chatID)
+	if err != nil {
+		return fmt.Errorf("failed to get chat cipher: %v", err)
+	}
+	data, err = cc.open(data)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt state file: %v", err)
+	}
+
+	var state chatState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to decode state file: %v", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	chat, exists := k.chats[chatID]
+	if !exists {
+		chat = CompleteChat{
+			ChatId: chatID,
+			Chats:  make(map[int]ChatMessage),
+		}
+	}
+	chat.DailyMessageCount = state.DailyMessageCount
+	chat.LastMessageDate = state.LastMessageDate
+	chat.LimitNoticeDate = state.LimitNoticeDate
+	chat.PendingReplyMsgID = state.PendingReplyMsgID
+	chat.CrisisFlaggedAt = state.CrisisFlaggedAt
+	chat.Persona = state.Persona
+	chat.Language = state.Language
+	chat.Timezone = state.Timezone
+	chat.ProactiveSent = state.ProactiveSent
+	chat.LastProactiveAttempt = state.LastProactiveAttempt
+	chat.UnansweredProactive = state.UnansweredProactive
+	chat.FollowedUpEvents = state.FollowedUpEvents
+	chat.Greeted = state.Greeted
+	k.chats[chatID] = chat
+	return nil
+}
+
+// saveChatState saves the counters and queue of a chat to state.json. It
+// holds personal data like event descriptions and names, it is encrypted
+// like the chat.
+func (k *KiraBot) saveChatState(chat CompleteChat) error {
+	chatDir := chatDirPath(chat.ChatId)
+	if err := os.MkdirAll(chatDir, 0700); err != nil {
+		return fmt.Errorf("failed to create chat directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(chatState{
+		DailyMessageCount: chat.DailyMessageCount,
+		LastMessageDate:   chat.LastMessageDate,
+		LimitNoticeDate:   chat.LimitNoticeDate,
+		PendingReplyMsgID: chat.PendingReplyMsgID,
+		CrisisFlaggedAt:   chat.CrisisFlaggedAt,
+		Persona:           chat.Persona,
+		Language:          chat.Language,
+		Timezone:          chat.Timezone,
+
+		ProactiveSent:        chat.ProactiveSent,
+		LastProactiveAttempt: chat.LastProactiveAttempt,
+		UnansweredProactive:  chat.UnansweredProactive,
+
+		FollowedUpEvents: chat.FollowedUpEvents,
+		Greeted:          chat.Greeted,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat state: %v", err)
+	}
+
+	cc, err := k.chatCipherFor(chat.ChatId)
+	if err != nil {
+		return fmt.Errorf("failed to get chat cipher: %v", err)
+	}
+	data, err = cc.seal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt chat state: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(chatDir, "state.json"), data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,66 @@
+package kira
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// llmSafetySettings switch Gemini's own filters off (threshold 5), moderation
+// and guardrails are handled by the bot
+var llmSafetySettings = []*genai.SafetySetting{
+	{
+		Category:  genai.HarmCategoryHarassment,
+		Threshold: 5,
+	},
+	{
+		Category:  genai.HarmCategoryHateSpeech,
+		Threshold: 5,
+	},
+	{
+		Category:  genai.HarmCategorySexuallyExplicit,
+		Threshold: 5,
+	},
+	{
+		Category:  genai.HarmCategoryDangerousContent,
+		Threshold: 5,
+	},
+}
+
+// llmRegistry holds the genai client that all LLM calls share. The client
+// keeps its gRPC connection open, models are cheap per-call configurations.
+type llmRegistry struct {
+	client *genai.Client
+}
+
+// newLLMRegistry creates the shared client, opts are added to the API key,
+// e.g. another endpoint
+func newLLMRegistry(apiKey string, opts ...option.ClientOption) (*llmRegistry, error) {
+	client, err := genai.NewClient(context.Background(), append([]option.ClientOption{option.WithAPIKey(apiKey)}, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create genai client: %w", err)
+	}
+	return &llmRegistry{client: client}, nil
+}
+
+// model returns a fresh configuration of a model on the shared client.
+// Callers set system instruction, temperature and schema on it.
+func (r *llmRegistry) model(name string) *genai.GenerativeModel {
+	model := r.client.GenerativeModel(name)
+	model.SafetySettings = llmSafetySettings
+	return model
+}
+
+// modelFromCache returns a model on the shared client that uses a cached
+// system instruction and content. Safety settings are per request.
+func (r *llmRegistry) modelFromCache(name, modelName string) *genai.GenerativeModel {
+	model := r.client.GenerativeModelFromCachedContent(&genai.CachedContent{Name: name, Model: modelName})
+	model.SafetySettings = llmSafetySettings
+	return model
+}
+
+func (r *llmRegistry) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,71 @@
+package kira
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// stubGemini answers every generateContent call with a short talk response
+func stubGemini(tb testing.TB) *httptest.Server {
+	tb.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"{\"respond\":true,\"text\":\"Hi\"}"}]},"finishReason":1}],` +
+			`"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":5,"totalTokenCount":15}}`))
+	}))
+	tb.Cleanup(server.Close)
+	return server
+}
+
+// stubOptions point a client at the stub. Every call gets its own transport
+// like a client created with genai.NewClient, so TLS is negotiated per client.
+func stubOptions(server *httptest.Server) []option.ClientOption {
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	return []option.ClientOption{
+		option.WithEndpoint(server.URL),
+		option.WithHTTPClient(&http.Client{Transport: transport}),
+	}
+}
+
+func generateStub(tb testing.TB, model *genai.GenerativeModel) {
+	resp, err := model.GenerateContent(context.Background(), genai.Text("Hallo"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := parseTalkResponse(string(resp.Candidates[0].Content.Parts[0].(genai.Text))); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// BenchmarkLLMClient compares a client per call, as before the registry,
+// with the shared client of llmRegistry
+func BenchmarkLLMClient(b *testing.B) {
+	server := stubGemini(b)
+
+	b.Run("per-call", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			llm, err := newLLMRegistry("test", stubOptions(server)...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			generateStub(b, llm.model(talkModelName))
+			llm.Close()
+		}
+	})
+
+	b.Run("shared", func(b *testing.B) {
+		llm, err := newLLMRegistry("test", stubOptions(server)...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer llm.Close()
+		for i := 0; i < b.N; i++ {
+			generateStub(b, llm.model(talkModelName))
+		}
+	})
+}
@@ -0,0 +1,495 @@
+package kira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gitea.karlbreuer.com/karl1b/kira/pkg/settings"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ModerationAction is what happens with a message that a stage flagged
+type ModerationAction string
+
+const (
+	ActionDrop   ModerationAction = "drop"   // keep the message out of the LLM context, don't reply to it
+	ActionRedact ModerationAction = "redact" // replace the flagged parts
+	ActionRefuse ModerationAction = "refuse" // inbound: answer with the refusal text, outbound: don't send the reply
+	ActionAlert  ModerationAction = "alert"  // notify the admin chat
+)
+
+const redactedText = "[…]"
+
+// moderationDirection tells the pipeline whether it checks user messages or Kira's replies
+type moderationDirection int
+
+const (
+	inbound moderationDirection = iota
+	outbound
+)
+
+// moderationHit is one match of a stage. Start and End are -1 if the whole text is flagged.
+type moderationHit struct {
+	Rule  string
+	Start int
+	End   int
+}
+
+// moderationStage checks a text, e.g. against a wordlist or a classifier
+type moderationStage interface {
+	name() string
+	check(text string) ([]moderationHit, error)
+}
+
+// configuredStage is a stage with the actions for both directions
+type configuredStage struct {
+	stage    moderationStage
+	inbound  []ModerationAction
+	outbound []ModerationAction
+}
+
+// moderationPipeline runs all stages on inbound messages and outbound replies
+type moderationPipeline struct {
+	stages      []configuredStage
+	refusalText string           // empty uses the refusal of the chat's language pack
+	wordlists   []*wordlistStage // also used to clean data after the LLM blocked a request
+}
+
+// moderationResult is the combined outcome of all stages
+type moderationResult struct {
+	Text    string // the text after redaction
+	Actions map[ModerationAction]bool
+	Hits    []string // "stage:rule" for logging
+}
+
+func (r moderationResult) has(action ModerationAction) bool {
+	return r.Actions[action]
+}
+
+// ModerationConfig is the content of the moderation file
+type ModerationConfig struct {
+	RefusalText string                  `json:"refusal_text"` // overrides the language packs
+	Stages      []ModerationStageConfig `json:"stages"`
+}
+
+// ModerationStageConfig configures a single stage
+type ModerationStageConfig struct {
+	Type      string             `json:"type"`      // "wordlist", "regex" or "classifier"
+	Language  string             `json:"language"`  // wordlist: language of the list
+	File      string             `json:"file"`      // wordlist: one word or phrase per line
+	Rules     []RegexRuleConfig  `json:"rules"`     // regex
+	URL       string             `json:"url"`       // classifier: endpoint
+	Threshold float64            `json:"threshold"` // classifier: minimum score to flag
+	Inbound   []ModerationAction `json:"inbound"`
+	Outbound  []ModerationAction `json:"outbound"`
+}
+
+// RegexRuleConfig is a named regular expression
+type RegexRuleConfig struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// loadModeration loads the moderation file. Without a file only the
+// wordlists in moderation/ are loaded, which are used to clean data after the
+// LLM blocked a request.
+func loadModeration(path string) (*moderationPipeline, error) {
+	config := ModerationConfig{Stages: defaultWordlistStages()}
+
+	usingDefault := false
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read moderation file: %w", err)
+		}
+		log.Printf("No moderation file %s found, only using the default wordlist", path)
+		usingDefault = true
+	} else if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode moderation file: %w", err)
+	}
+
+	pipeline := &moderationPipeline{refusalText: config.RefusalText}
+
+	for i, sc := range config.Stages {
+		if err := checkActions(sc.Inbound, sc.Outbound); err != nil {
+			return nil, fmt.Errorf("stage %d: %w", i, err)
+		}
+
+		var stage moderationStage
+		switch sc.Type {
+		case "wordlist":
+			ws, err := newWordlistStage(sc.Language, sc.File)
+			if err != nil {
+				if usingDefault {
+					log.Printf("Warning: %v", err)
+					continue
+				}
+				return nil, fmt.Errorf("stage %d: %w", i, err)
+			}
+			pipeline.wordlists = append(pipeline.wordlists, ws)
+			stage = ws
+		case "regex":
+			rs, err := newRegexStage(sc.Rules)
+			if err != nil {
+				return nil, fmt.Errorf("stage %d: %w", i, err)
+			}
+			stage = rs
+		case "classifier":
+			if sc.URL == "" {
+				return nil, fmt.Errorf("stage %d: classifier needs a url", i)
+			}
+			stage = &classifierStage{url: sc.URL, threshold: sc.Threshold, client: &http.Client{Timeout: 10 * time.Second}}
+		default:
+			return nil, fmt.Errorf("stage %d: unknown type %q", i, sc.Type)
+		}
+
+		pipeline.stages = append(pipeline.stages, configuredStage{stage: stage, inbound: sc.Inbound, outbound: sc.Outbound})
+	}
+
+	log.Printf("Loaded moderation pipeline with %d stages", len(pipeline.stages))
+	return pipeline, nil
+}
+
+// defaultWordlistStages returns a stage for every moderation/wordlist_<language>.txt
+func defaultWordlistStages() []ModerationStageConfig {
+	files, _ := filepath.Glob(filepath.Join("moderation", "wordlist_*.txt"))
+	stages := make([]ModerationStageConfig, 0, len(files))
+	for _, file := range files {
+		language := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "wordlist_"), ".txt")
+		stages = append(stages, ModerationStageConfig{Type: "wordlist", Language: language, File: file})
+	}
+	return stages
+}
+
+func checkActions(lists ...[]ModerationAction) error {
+	for _, actions := range lists {
+		for _, a := range actions {
+			switch a {
+			case ActionDrop, ActionRedact, ActionRefuse, ActionAlert:
+			default:
+				return fmt.Errorf("unknown action %q", a)
+			}
+		}
+	}
+	return nil
+}
+
+// moderate runs all stages on text. Stage errors are logged and the stage is skipped.
+func (p *moderationPipeline) moderate(text string, dir moderationDirection) moderationResult {
+	result := moderationResult{Text: text, Actions: make(map[ModerationAction]bool)}
+	if text == "" {
+		return result
+	}
+
+	var redactions []moderationHit
+	for _, cs := range p.stages {
+		actions := cs.inbound
+		if dir == outbound {
+			actions = cs.outbound
+		}
+		if len(actions) == 0 {
+			continue
+		}
+
+		hits, err := cs.stage.check(text)
+		if err != nil {
+			log.Printf("Moderation stage %s failed: %v", cs.stage.name(), err)
+			continue
+		}
+		if len(hits) == 0 {
+			continue
+		}
+
+		for _, hit := range hits {
+			result.Hits = append(result.Hits, cs.stage.name()+":"+hit.Rule)
+		}
+		for _, a := range actions {
+			result.Actions[a] = true
+			if a == ActionRedact {
+				redactions = append(redactions, hits...)
+			}
+		}
+	}
+
+	if len(redactions) > 0 {
+		result.Text = redact(text, redactions)
+	}
+	return result
+}
+
+// redact replaces the hit ranges in text, a hit without range replaces everything
+func redact(text string, hits []moderationHit) string {
+	for _, h := range hits {
+		if h.Start < 0 {
+			return redactedText
+		}
+	}
+
+	// Merge overlapping and nested ranges, then replace from the end so the
+	// earlier offsets stay valid
+	ranges := make([]moderationHit, 0, len(hits))
+	for _, h := range hits {
+		if h.Start < h.End {
+			ranges = append(ranges, h)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	var merged []moderationHit
+	for _, h := range ranges {
+		if n := len(merged); n > 0 && h.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, h.End)
+			continue
+		}
+		merged = append(merged, h)
+	}
+
+	for i := len(merged) - 1; i >= 0; i-- {
+		h := merged[i]
+		text = text[:h.Start] + redactedText + text[min(h.End, len(text)):]
+	}
+	return text
+}
+
+// sanitizer returns a SimpleSanitizer with the words of all wordlists
+func (p *moderationPipeline) sanitizer() *SimpleSanitizer {
+	s := NewSimpleSanitizer()
+	for _, ws := range p.wordlists {
+		s.AddFilterItems(ws.words)
+	}
+	return s
+}
+
+// wordlistStage matches words and phrases from a file
+type wordlistStage struct {
+	language string
+	words    []string
+	regexes  []*regexp.Regexp
+}
+
+func newWordlistStage(language, file string) (*wordlistStage, error) {
+	words, err := readWordlist(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &wordlistStage{language: language, words: words}
+	s := NewSimpleSanitizer()
+	for _, w := range words {
+		if re := s.createPhraseRegex(s.normalize(w)); re != nil {
+			ws.regexes = append(ws.regexes, re)
+		}
+	}
+
+	log.Printf("Loaded %d words for language %q from %s", len(words), language, file)
+	return ws, nil
+}
+
+// readWordlist reads one word or phrase per line, skipping empty lines and # comments
+func readWordlist(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wordlist: %w", err)
+	}
+
+	var words []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words, nil
+}
+
+func (w *wordlistStage) name() string {
+	return "wordlist_" + w.language
+}
+
+func (w *wordlistStage) check(text string) ([]moderationHit, error) {
+	var hits []moderationHit
+	for i, re := range w.regexes {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			hits = append(hits, moderationHit{Rule: w.words[i], Start: loc[0], End: loc[1]})
+		}
+	}
+	return hits, nil
+}
+
+// regexStage matches named regular expressions
+type regexStage struct {
+	names   []string
+	regexes []*regexp.Regexp
+}
+
+func newRegexStage(rules []RegexRuleConfig) (*regexStage, error) {
+	rs := &regexStage{}
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", r.Name, err)
+		}
+		rs.names = append(rs.names, r.Name)
+		rs.regexes = append(rs.regexes, re)
+	}
+	return rs, nil
+}
+
+func (r *regexStage) name() string {
+	return "regex"
+}
+
+func (r *regexStage) check(text string) ([]moderationHit, error) {
+	var hits []moderationHit
+	for i, re := range r.regexes {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			hits = append(hits, moderationHit{Rule: r.names[i], Start: loc[0], End: loc[1]})
+		}
+	}
+	return hits, nil
+}
+
+// classifierStage asks an external classifier. It posts {"text": "..."} and
+// expects {"label": "...", "score": 0.0-1.0}.
+type classifierStage struct {
+	url       string
+	threshold float64
+	client    *http.Client
+}
+
+func (c *classifierStage) name() string {
+	return "classifier"
+}
+
+func (c *classifierStage) check(text string) ([]moderationHit, error) {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("classifier returned %s", resp.Status)
+	}
+
+	var result struct {
+		Label string  `json:"label"`
+		Score float64 `json:"score"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode classifier response: %v", err)
+	}
+
+	if result.Score < c.threshold {
+		return nil, nil
+	}
+	return []moderationHit{{Rule: result.Label, Start: -1, End: -1}}, nil
+}
+
+// moderateInbound applies the pipeline to a user message before it is stored.
+// It returns the message to store.
+func (k *KiraBot) moderateInbound(msg ChatMessage) ChatMessage {
+	result := k.moderation.moderate(msg.Text, inbound)
+	if len(result.Actions) == 0 {
+		return msg
+	}
+
+	log.Printf("[MODERATION] Inbound message %d in chat %d flagged: %v", msg.MessageID, msg.ChatID, result.Hits)
+
+	// A message that is already handled (e.g. by the crisis detector) gets no refusal
+	handled := msg.ShouldNotRespond
+
+	if result.has(ActionRedact) {
+		msg.Text = result.Text
+	}
+	if result.has(ActionDrop) || result.has(ActionRefuse) {
+		msg.DroppedFromContext = true
+		msg.ShouldNotRespond = true
+	}
+	if result.has(ActionAlert) {
+		k.alertAdmin(fmt.Sprintf("Moderation: message %d in chat %d (%s) flagged by %s",
+			msg.MessageID, msg.ChatID, msg.Username, strings.Join(result.Hits, ", ")))
+	}
+	if result.has(ActionRefuse) && !handled {
+		refusal := k.moderation.refusalText
+		if refusal == "" {
+			refusal = k.languageForMessage(msg.ChatID, msg.Username, msg.LanguageCode).Text.Refusal
+		}
+		go func() {
+			if err := k.sendMessage(msg.ChatID, refusal, ""); err != nil {
+				log.Printf("Error sending refusal: %v", err)
+			}
+		}()
+	}
+
+	return msg
+}
+
+// moderateOutbound applies the pipeline to a reply. It returns the reply to
+// send and false if the reply must not be sent.
+func (k *KiraBot) moderateOutbound(chatID int64, reply string) (string, bool) {
+	result := k.moderation.moderate(reply, outbound)
+	if len(result.Actions) == 0 {
+		return reply, true
+	}
+
+	log.Printf("[MODERATION] Reply in chat %d flagged: %v", chatID, result.Hits)
+
+	if result.has(ActionAlert) {
+		k.alertAdmin(fmt.Sprintf("Moderation: reply in chat %d flagged by %s", chatID, strings.Join(result.Hits, ", ")))
+	}
+	if result.has(ActionDrop) || result.has(ActionRefuse) {
+		return "", false
+	}
+	if result.has(ActionRedact) {
+		return result.Text, true
+	}
+	return reply, true
+}
+
+// alertAdmin notifies the admin chat. The alert is not stored as a chat message.
+func (k *KiraBot) alertAdmin(text string) {
+	log.Printf("[ADMIN_ALERT] %s", text)
+
+	if settings.Settings.AdminChatID == 0 {
+		return
+	}
+	if _, err := k.api.Send(tgbotapi.NewMessage(settings.Settings.AdminChatID, text)); err != nil {
+		log.Printf("Error sending admin alert: %v", err)
+	}
+}
+
+// contextMessages removes messages that were dropped by moderation from the LLM context
+func contextMessages(messages []ChatMessage) []ChatMessage {
+	filtered := make([]ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		if !msg.DroppedFromContext {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,29 @@
+package kira
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		hits []moderationHit
+		want string
+	}{
+		{"no hits", "hello world", nil, "hello world"},
+		{"one range", "hello world", []moderationHit{{Start: 6, End: 11}}, "hello […]"},
+		{"two ranges", "a bad and worse b", []moderationHit{{Start: 10, End: 15}, {Start: 2, End: 5}}, "a […] and […] b"},
+		{"overlapping", "0123456789abc", []moderationHit{{Start: 1, End: 4}, {Start: 3, End: 6}, {Start: 8, End: 9}}, "0[…]67[…]9abc"},
+		{"nested", "0123456789abc", []moderationHit{{Start: 0, End: 10}, {Start: 2, End: 5}}, "[…]abc"},
+		{"adjacent", "0123456789", []moderationHit{{Start: 2, End: 4}, {Start: 4, End: 6}}, "01[…]6789"},
+		{"empty range", "hello", []moderationHit{{Start: 2, End: 2}}, "hello"},
+		{"past the end", "hello", []moderationHit{{Start: 3, End: 9}}, "hel[…]"},
+		{"whole message", "hello", []moderationHit{{Start: 1, End: 2}, {Start: -1, End: -1}}, redactedText},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact(tt.text, tt.hits); got != tt.want {
+				t.Errorf("redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
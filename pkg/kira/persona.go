@@ -0,0 +1,245 @@
+package kira
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Persona defines a companion. Every YAML file in a language directory of
+// personas/ is one persona in that language, the system prompt is assembled
+// from it with the template next to it.
+type Persona struct {
+	ID       string            `yaml:"-"` // file name without .yaml, stored on the chat
+	Language string            `yaml:"-"` // code of the language directory the file is in
+	Name     string            `yaml:"name"`
+	Template string            `yaml:"template"` // relative to the persona file, default prompt.tmpl
+	Variants map[string]string `yaml:"variants"` // named prompt variants for experiments, name -> template file
+	Seed     Character         `yaml:"seed"`     // the persona's part of a new memory form, locked for the helper
+
+	Goals       []string `yaml:"goals"`
+	Background  []string `yaml:"background"`
+	Personality []string `yaml:"personality"`
+	Style       []string `yaml:"style"`
+	Behavior    []string `yaml:"behavior"`
+	Taboos      []string `yaml:"taboos"`
+	Rules       []string `yaml:"rules"`
+
+	Emojis           []string `yaml:"emojis"`             // typical emojis, named in the prompt
+	EmojiSplitChance float64  `yaml:"emoji_split_chance"` // chance that an emoji at the end is sent as its own message
+
+	WakingHours struct {
+		Wake  int `yaml:"wake"`
+		Sleep int `yaml:"sleep"`
+	} `yaml:"waking_hours"`
+
+	Proactive ProactivePolicy `yaml:"proactive"` // when the persona writes on her own, see proactive.go
+
+	prompts map[string]*template.Template // by variant name, the template is "default"
+}
+
+// defaultVariant is the name of the persona's template among its variants
+const defaultVariant = "default"
+
+// promptData is passed to the prompt template
+type promptData struct {
+	*Persona
+	MustAnswer bool // the model has to reply, the optional-response rules are left out
+}
+
+var promptFuncs = template.FuncMap{"join": strings.Join}
+
+// personaSet holds all personas of the deployment in all languages
+type personaSet struct {
+	byLanguage  map[string]map[string]*Persona // language code -> persona ID -> persona
+	defaultID   string
+	defaultLang string
+}
+
+// loadPersonas loads every .yaml file in the language directories of dir,
+// e.g. personas/de/kira.yaml. New chats without an assignment and chats from
+// before personas existed get defaultID.
+func loadPersonas(dir, defaultID string, languages *languageSet) (*personaSet, error) {
+	set := &personaSet{
+		byLanguage:  make(map[string]map[string]*Persona),
+		defaultID:   defaultID,
+		defaultLang: languages.defaultCode,
+	}
+	for code := range languages.packs {
+		paths, err := filepath.Glob(filepath.Join(dir, code, "*.yaml"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list persona files: %w", err)
+		}
+		set.byLanguage[code] = make(map[string]*Persona)
+		for _, path := range paths {
+			p, err := loadPersona(path)
+			if err != nil {
+				return nil, err
+			}
+			p.Language = code
+			set.byLanguage[code][p.ID] = p
+			log.Printf("Loaded persona %s (%s, %s)", p.ID, p.Name, code)
+		}
+	}
+
+	if _, ok := set.byLanguage[set.defaultLang][defaultID]; !ok {
+		return nil, fmt.Errorf("default persona %q not found in %s", defaultID, filepath.Join(dir, set.defaultLang))
+	}
+	return set, nil
+}
+
+// get returns a persona in a language. A persona that isn't translated is
+// used in the default language, an unknown ID gives the default persona.
+func (s *personaSet) get(id, lang string) *Persona {
+	for _, candidate := range []struct{ id, lang string }{
+		{id, lang}, {id, s.defaultLang}, {s.defaultID, lang}, {s.defaultID, s.defaultLang},
+	} {
+		if p, ok := s.byLanguage[candidate.lang][candidate.id]; ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// exists reports whether a persona with that id was loaded in any language
+func (s *personaSet) exists(id string) bool {
+	for _, personas := range s.byLanguage {
+		if _, ok := personas[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func loadPersona(path string) (*Persona, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read persona file: %w", err)
+	}
+
+	p := &Persona{ID: strings.TrimSuffix(filepath.Base(path), ".yaml")}
+	p.WakingHours.Wake = 10
+	p.WakingHours.Sleep = 22
+	p.EmojiSplitChance = 0.5
+	p.Proactive = defaultProactivePolicy()
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to decode persona file: %w", err)
+	}
+
+	if p.Name == "" {
+		return nil, fmt.Errorf("persona file %s: name is missing", path)
+	}
+	if p.EmojiSplitChance < 0 || p.EmojiSplitChance > 1 {
+		return nil, fmt.Errorf("persona file %s: emoji_split_chance must be between 0 and 1", path)
+	}
+	if p.Seed.Name == "" {
+		p.Seed.Name = p.Name
+	}
+	wake, sleep := p.WakingHours.Wake, p.WakingHours.Sleep
+	if wake < 0 || sleep > 24 || wake >= sleep {
+		return nil, fmt.Errorf("persona file %s: invalid waking hours %d-%d", path, wake, sleep)
+	}
+	if err := p.Proactive.check(); err != nil {
+		return nil, fmt.Errorf("persona file %s: proactive: %w", path, err)
+	}
+
+	if p.Template == "" {
+		p.Template = "prompt.tmpl"
+	}
+	if _, ok := p.Variants[defaultVariant]; ok {
+		return nil, fmt.Errorf("persona file %s: variant name %q is reserved for the template", path, defaultVariant)
+	}
+	p.prompts = make(map[string]*template.Template)
+	for name, file := range p.Variants {
+		if err := p.loadPrompt(path, name, file); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.loadPrompt(path, defaultVariant, p.Template); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// loadPrompt parses the template of a prompt variant, file is relative to the persona file
+func (p *Persona) loadPrompt(path, variant, file string) error {
+	templatePath := filepath.Join(filepath.Dir(path), file)
+	prompt, err := template.New(filepath.Base(templatePath)).Funcs(promptFuncs).ParseFiles(templatePath)
+	if err != nil {
+		return fmt.Errorf("failed to load prompt template of variant %s: %w", variant, err)
+	}
+	p.prompts[variant] = prompt
+
+	// Fail at startup and not on the first message
+	_, err = p.systemPrompt(variant, false)
+	return err
+}
+
+// hasVariant reports whether the persona has a prompt variant of that name
+func (p *Persona) hasVariant(variant string) bool {
+	_, ok := p.prompts[variant]
+	return ok
+}
+
+// systemPrompt assembles the system prompt of the talk call with a prompt
+// variant, an unknown or empty variant gives the persona's template
+func (p *Persona) systemPrompt(variant string, mustAnswer bool) (string, error) {
+	prompt, ok := p.prompts[variant]
+	if !ok {
+		prompt = p.prompts[defaultVariant]
+	}
+	var b strings.Builder
+	if err := prompt.Execute(&b, promptData{Persona: p, MustAnswer: mustAnswer}); err != nil {
+		return "", fmt.Errorf("failed to execute prompt template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// isAwake reports whether the persona is awake at t
+func (p *Persona) isAwake(t time.Time) bool {
+	return t.Hour() >= p.WakingHours.Wake && t.Hour() < p.WakingHours.Sleep
+}
+
+// personaFor returns the persona of a chat in the chat's language
+func (k *KiraBot) personaFor(chat CompleteChat) *Persona {
+	return k.personas.get(chat.Persona, k.languageFor(chat).Code)
+}
+
+// personaForChatID is personaFor for callers that only have the chat ID.
+// It takes k.mu.
+func (k *KiraBot) personaForChatID(chatID int64) *Persona {
+	k.mu.Lock()
+	chat := k.chats[chatID]
+	k.mu.Unlock()
+	return k.personaFor(chat)
+}
+
+// setupNewChat gives a new chat its language and the persona the user is
+// assigned to in the plans file and seeds the persona's side of the memory
+// form. Both stay with the chat even if the assignment changes later, the
+// memory belongs to them. k.mu must be held.
+func (k *KiraBot) setupNewChat(chat *CompleteChat, msg ChatMessage) {
+	chat.Language = k.languages.resolve(k.plans.languageFor(msg.Username), msg.LanguageCode)
+
+	id := k.plans.personaFor(msg.Username)
+	if id != "" && !k.personas.exists(id) {
+		log.Printf("User %s is assigned to unknown persona %q, using %s", msg.Username, id, k.personas.defaultID)
+	}
+	persona := k.personas.get(id, chat.Language)
+
+	chat.Persona = persona.ID
+	chat.Infos = createEmptyKiraHelperForm()
+	chat.Infos.Persona = normalizeCharacter(persona.Seed)
+	log.Printf("Chat %d talks to persona %s in %s", chat.ChatId, persona.ID, persona.Language)
+
+	if err := k.saveChatState(*chat); err != nil {
+		log.Printf("Error saving chat state: %v", err)
+	}
+}
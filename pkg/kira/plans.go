@@ -0,0 +1,214 @@
+package kira
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gitea.karlbreuer.com/karl1b/kira/pkg/settings"
+)
+
+// Plan is the usage tier of a user with the defaults filled in
+type Plan struct {
+	Name               string
+	DailyMessages      int  // replies per day
+	DailyTokenBudget   int  // 0 disables the budget
+	MonthlyTokenBudget int  // 0 disables the budget
+	ProactiveMessages  bool // Kira may start conversations on her own
+	MediaProcessing    bool // Kira reacts to photos, voice messages etc.
+}
+
+// PlanConfig is a plan in the plans file. A missing number uses the
+// default, an explicit 0 is kept.
+type PlanConfig struct {
+	DailyMessages      *int `json:"daily_messages"`       // replies per day, missing uses the default limit
+	DailyTokenBudget   *int `json:"daily_token_budget"`   // missing uses DAILYTOKENBUDGET from .env, 0 disables it
+	MonthlyTokenBudget *int `json:"monthly_token_budget"` // missing uses MONTHLYTOKENBUDGET from .env, 0 disables it
+	ProactiveMessages  bool `json:"proactive_messages"`
+	MediaProcessing    bool `json:"media_processing"`
+}
+
+// PlansConfig is the content of the plans file
+type PlansConfig struct {
+	DefaultPlan string                `json:"default_plan"`
+	Plans       map[string]PlanConfig `json:"plans"`
+	Users       map[string]string     `json:"users"`     // Telegram username -> plan name
+	Personas    map[string]string     `json:"personas"`  // Telegram username -> persona for new chats
+	Languages   map[string]string     `json:"languages"` // Telegram username -> language for new chats
+
+	Proactive map[string]ProactiveOverride `json:"proactive"` // Telegram username -> changes to the persona's proactive policy
+}
+
+// planStore holds the plans file and reloads it when it changes on disk
+type planStore struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	config  PlansConfig
+}
+
+// defaultPlan is used if there is no plans file or a user's plan doesn't exist
+func defaultPlan() Plan {
+	return Plan{
+		Name:               "default",
+		DailyMessages:      dailyLimit,
+		DailyTokenBudget:   settings.Settings.DailyTokenBudget,
+		MonthlyTokenBudget: settings.Settings.MonthlyTokenBudget,
+		ProactiveMessages:  true,
+		MediaProcessing:    true,
+	}
+}
+
+func newPlanStore(path string) *planStore {
+	ps := &planStore{path: path}
+	ps.reload()
+	return ps
+}
+
+// reload reads the plans file if it changed since the last read.
+// The last good config is kept if the file can't be read.
+func (ps *planStore) reload() {
+	info, err := os.Stat(ps.path)
+	if err != nil {
+		if !ps.modTime.IsZero() {
+			log.Printf("Warning: plans file %s not readable, keeping last plans: %v", ps.path, err)
+		}
+		return
+	}
+	if info.ModTime().Equal(ps.modTime) {
+		return
+	}
+
+	config, err := loadPlansConfig(ps.path)
+	if err != nil {
+		log.Printf("Warning: failed to load plans file %s, keeping last plans: %v", ps.path, err)
+		return
+	}
+
+	ps.config = config
+	ps.modTime = info.ModTime()
+	log.Printf("Loaded %d plans and %d user assignments from %s", len(config.Plans), len(config.Users), ps.path)
+}
+
+// loadPlansConfig reads and checks a plans file
+func loadPlansConfig(path string) (PlansConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PlansConfig{}, err
+	}
+
+	var config PlansConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return PlansConfig{}, fmt.Errorf("failed to decode plans file: %v", err)
+	}
+
+	if config.DefaultPlan != "" {
+		if _, ok := config.Plans[config.DefaultPlan]; !ok {
+			return PlansConfig{}, fmt.Errorf("default plan %q is not defined", config.DefaultPlan)
+		}
+	}
+	for name, plan := range config.Plans {
+		for field, value := range map[string]*int{
+			"daily_messages":       plan.DailyMessages,
+			"daily_token_budget":   plan.DailyTokenBudget,
+			"monthly_token_budget": plan.MonthlyTokenBudget,
+		} {
+			if value != nil && *value < 0 {
+				return PlansConfig{}, fmt.Errorf("plan %q: %s must not be negative", name, field)
+			}
+		}
+	}
+	for user, plan := range config.Users {
+		if _, ok := config.Plans[plan]; !ok {
+			return PlansConfig{}, fmt.Errorf("user %s is assigned to unknown plan %q", user, plan)
+		}
+	}
+
+	return config, nil
+}
+
+// planFor returns the plan of a Telegram username
+func (ps *planStore) planFor(username string) Plan {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.reload()
+
+	name, ok := ps.config.Users[username]
+	if !ok {
+		name = ps.config.DefaultPlan
+	}
+
+	config, ok := ps.config.Plans[name]
+	if !ok {
+		return defaultPlan()
+	}
+
+	plan := defaultPlan()
+	plan.Name = name
+	plan.ProactiveMessages = config.ProactiveMessages
+	plan.MediaProcessing = config.MediaProcessing
+	if config.DailyMessages != nil {
+		plan.DailyMessages = *config.DailyMessages
+	}
+	if config.DailyTokenBudget != nil {
+		plan.DailyTokenBudget = *config.DailyTokenBudget
+	}
+	if config.MonthlyTokenBudget != nil {
+		plan.MonthlyTokenBudget = *config.MonthlyTokenBudget
+	}
+	return plan
+}
+
+// personaFor returns the persona a user is assigned to, empty for the default persona
+func (ps *planStore) personaFor(username string) string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.reload()
+	return ps.config.Personas[username]
+}
+
+// languageFor returns the language a user is assigned to, empty if none
+func (ps *planStore) languageFor(username string) string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.reload()
+	return ps.config.Languages[username]
+}
+
+// proactiveFor returns the proactive policy values of a user, false if there are none
+func (ps *planStore) proactiveFor(username string) (ProactiveOverride, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.reload()
+	policy, ok := ps.config.Proactive[username]
+	return policy, ok
+}
+
+// chatUsername returns the username of the user in a chat
+func chatUsername(chat CompleteChat) string {
+	var username string
+	lastID := -1
+	for _, msg := range chat.Chats {
+		if !msg.IsBot && msg.Username != "" && msg.MessageID > lastID {
+			username = msg.Username
+			lastID = msg.MessageID
+		}
+	}
+	return username
+}
+
+// planForChat returns the plan of the user in a chat
+func (k *KiraBot) planForChat(chat CompleteChat) Plan {
+	k.mu.Lock()
+	username := chatUsername(chat)
+	k.mu.Unlock()
+
+	return k.plans.planFor(username)
+}
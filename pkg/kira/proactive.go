@@ -0,0 +1,238 @@
+package kira
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"strconv"
+	"time"
+)
+
+const proactiveWeek = 7 * 24 * time.Hour
+
+// ProactivePolicy decides when a persona writes on her own after the chat
+// went quiet. Personas set it in their YAML file, users can get their own
+// values in plans.json, see ProactiveOverride.
+type ProactivePolicy struct {
+	QuietHours QuietHours `yaml:"quiet_hours" json:"quiet_hours"`
+
+	MinSilenceHours float64 `yaml:"min_silence_hours" json:"min_silence_hours"` // silence before the first proactive message
+	MaxSilenceHours float64 `yaml:"max_silence_hours" json:"max_silence_hours"` // the back-off never waits longer
+	MaxPerWeek      int     `yaml:"max_per_week" json:"max_per_week"`           // proactive messages in 7 days
+	Backoff         float64 `yaml:"backoff" json:"backoff"`                     // the silence is multiplied by this per unanswered proactive message
+	JitterMinutes   int     `yaml:"jitter_minutes" json:"jitter_minutes"`       // random delay, so not every chat gets its message at wake time
+}
+
+// QuietHours are no proactive messages from From to To o'clock, on top of
+// the waking hours. They can wrap midnight, equal hours mean none.
+type QuietHours struct {
+	From int `yaml:"from" json:"from"`
+	To   int `yaml:"to" json:"to"`
+}
+
+// ProactiveOverride are the values of a user's proactive policy in
+// plans.json. Missing fields keep the persona's value, an explicit 0 is
+// kept, e.g. max_per_week: 0 stops proactive messages.
+type ProactiveOverride struct {
+	QuietHours      *QuietHours `json:"quiet_hours"`
+	MinSilenceHours *float64    `json:"min_silence_hours"`
+	MaxSilenceHours *float64    `json:"max_silence_hours"`
+	MaxPerWeek      *int        `json:"max_per_week"`
+	Backoff         *float64    `json:"backoff"`
+	JitterMinutes   *int        `json:"jitter_minutes"`
+}
+
+// defaultProactivePolicy waits a day like Kira always did, backs off and
+// spreads the messages over two hours
+func defaultProactivePolicy() ProactivePolicy {
+	return ProactivePolicy{
+		MinSilenceHours: 24,
+		MaxSilenceHours: 7 * 24,
+		MaxPerWeek:      3,
+		Backoff:         2,
+		JitterMinutes:   120,
+	}
+}
+
+// check validates a complete policy
+func (p ProactivePolicy) check() error {
+	switch {
+	case p.MinSilenceHours <= 0:
+		return fmt.Errorf("min_silence_hours must be positive")
+	case p.MaxSilenceHours < p.MinSilenceHours:
+		return fmt.Errorf("max_silence_hours must not be below min_silence_hours")
+	case p.MaxPerWeek < 0:
+		return fmt.Errorf("max_per_week must not be negative")
+	case p.Backoff < 1:
+		return fmt.Errorf("backoff must be at least 1")
+	case p.JitterMinutes < 0:
+		return fmt.Errorf("jitter_minutes must not be negative")
+	case p.QuietHours.From < 0 || p.QuietHours.From > 23 || p.QuietHours.To < 0 || p.QuietHours.To > 23:
+		return fmt.Errorf("quiet_hours must be between 0 and 23")
+	}
+	return nil
+}
+
+// merge returns p with the fields that are set in override
+func (p ProactivePolicy) merge(override ProactiveOverride) ProactivePolicy {
+	if override.QuietHours != nil {
+		p.QuietHours = *override.QuietHours
+	}
+	if override.MinSilenceHours != nil {
+		p.MinSilenceHours = *override.MinSilenceHours
+	}
+	if override.MaxSilenceHours != nil {
+		p.MaxSilenceHours = *override.MaxSilenceHours
+	}
+	if override.MaxPerWeek != nil {
+		p.MaxPerWeek = *override.MaxPerWeek
+	}
+	if override.Backoff != nil {
+		p.Backoff = *override.Backoff
+	}
+	if override.JitterMinutes != nil {
+		p.JitterMinutes = *override.JitterMinutes
+	}
+	return p
+}
+
+// isQuiet reports whether t is in the quiet hours
+func (p ProactivePolicy) isQuiet(t time.Time) bool {
+	from, to, h := p.QuietHours.From, p.QuietHours.To, t.Hour()
+	if from == to {
+		return false
+	}
+	if from < to {
+		return h >= from && h < to
+	}
+	return h >= from || h < to
+}
+
+// silence is how long the chat has to be quiet after unanswered proactive messages
+func (p ProactivePolicy) silence(unanswered int) time.Duration {
+	hours := math.Min(p.MinSilenceHours*math.Pow(p.Backoff, float64(unanswered)), p.MaxSilenceHours)
+	return time.Duration(hours * float64(time.Hour))
+}
+
+// proactivePolicyFor returns the policy of a chat: the persona's, with the
+// user's values from the plans file
+func (k *KiraBot) proactivePolicyFor(chat CompleteChat) ProactivePolicy {
+	policy := k.personaFor(chat).Proactive
+	if override, ok := k.plans.proactiveFor(chatUsername(chat)); ok {
+		merged := policy.merge(override)
+		if err := merged.check(); err != nil {
+			log.Printf("Ignoring proactive policy of %s in plans file: %v", chatUsername(chat), err)
+			return policy
+		}
+		return merged
+	}
+	return policy
+}
+
+// proactiveDueAt returns when the chat gets its next proactive message. It
+// is the same on every run until something happens in the chat, the jitter
+// is derived from the chat and its last activity.
+func (k *KiraBot) proactiveDueAt(chat CompleteChat, lastMsg ChatMessage, loc *time.Location) time.Time {
+	policy := k.proactivePolicyFor(chat)
+	persona := k.personaFor(chat)
+
+	last := max(lastMsg.Timestamp, chat.LastProactiveAttempt)
+	due := time.Unix(last, 0).In(loc).Add(policy.silence(chat.UnansweredProactive))
+
+	// Move it to the next full hour the persona is awake and not quiet
+	for i := 0; i < 48 && (!persona.isAwake(due) || policy.isQuiet(due)); i++ {
+		due = time.Date(due.Year(), due.Month(), due.Day(), due.Hour()+1, 0, 0, 0, loc)
+	}
+
+	if policy.JitterMinutes > 0 {
+		h := fnv.New64a()
+		h.Write([]byte(strconv.FormatInt(chat.ChatId, 10) + "/" + strconv.FormatInt(last, 10)))
+		due = due.Add(time.Duration(h.Sum64()%uint64(policy.JitterMinutes)) * time.Minute)
+	}
+	return due
+}
+
+// proactiveDue reports whether the chat should get a proactive message now,
+// now is in the chat's time zone
+func (k *KiraBot) proactiveDue(chat CompleteChat, lastMsg ChatMessage, now time.Time) bool {
+	policy := k.proactivePolicyFor(chat)
+
+	if !k.personaFor(chat).isAwake(now) || policy.isQuiet(now) {
+		return false
+	}
+	if now.Before(k.proactiveDueAt(chat, lastMsg, now.Location())) {
+		return false
+	}
+
+	sent := 0
+	for _, at := range chat.ProactiveSent {
+		if now.Sub(time.Unix(at, 0)) < proactiveWeek {
+			sent++
+		}
+	}
+	if sent >= policy.MaxPerWeek {
+		log.Printf("Chat %d had %d proactive messages this week, waiting", chat.ChatId, sent)
+		return false
+	}
+	return true
+}
+
+// recordProactive stores a proactive attempt made after the message lastMsgID.
+// Every attempt backs off the next one until the user answers, only sent
+// messages count for the week. It takes k.mu.
+func (k *KiraBot) recordProactive(chatID int64, lastMsgID int, sent bool) {
+	now := time.Now()
+
+	k.mu.Lock()
+	chat := k.chats[chatID]
+	chat.LastProactiveAttempt = now.Unix()
+	if !userWroteAfter(chat, lastMsgID) {
+		// A user message during the attempt already answered it
+		chat.UnansweredProactive++
+	}
+	if sent {
+		var recent []int64
+		for _, at := range chat.ProactiveSent {
+			if now.Sub(time.Unix(at, 0)) < proactiveWeek {
+				recent = append(recent, at)
+			}
+		}
+		chat.ProactiveSent = append(recent, now.Unix())
+	}
+	k.chats[chatID] = chat
+	k.mu.Unlock()
+
+	log.Printf("Proactive attempt for chat %d (sent: %v, unanswered: %d)", chatID, sent, chat.UnansweredProactive)
+	if err := k.saveChatState(chat); err != nil {
+		log.Printf("Error saving chat state: %v", err)
+	}
+}
+
+// userWroteAfter reports whether the chat has a user message newer than msgID
+func userWroteAfter(chat CompleteChat, msgID int) bool {
+	for id, msg := range chat.Chats {
+		if id > msgID && !msg.IsBot {
+			return true
+		}
+	}
+	return false
+}
+
+// resetProactiveBackoff is called when the user writes, the next proactive
+// message waits the minimum silence again. It takes k.mu.
+func (k *KiraBot) resetProactiveBackoff(chatID int64) {
+	k.mu.Lock()
+	chat, exists := k.chats[chatID]
+	if !exists || chat.UnansweredProactive == 0 {
+		k.mu.Unlock()
+		return
+	}
+	chat.UnansweredProactive = 0
+	k.chats[chatID] = chat
+	k.mu.Unlock()
+
+	if err := k.saveChatState(chat); err != nil {
+		log.Printf("Error saving chat state: %v", err)
+	}
+}
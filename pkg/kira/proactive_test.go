@@ -0,0 +1,205 @@
+package kira
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// proactiveBot returns a bot with one persona that is awake from 8 to 22
+// o'clock and has the given policy. plans is the content of the plans file,
+// empty for none.
+func proactiveBot(t *testing.T, policy ProactivePolicy, plans string) *KiraBot {
+	t.Helper()
+	languages, err := loadLanguages("../../lang", "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "plans.json")
+	if plans != "" {
+		if err := os.WriteFile(path, []byte(plans), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &Persona{ID: "kira", Name: "Kira", Proactive: policy}
+	p.WakingHours.Wake = 8
+	p.WakingHours.Sleep = 22
+	return &KiraBot{
+		languages: languages,
+		personas: &personaSet{
+			byLanguage:  map[string]map[string]*Persona{"de": {"kira": p}},
+			defaultID:   "kira",
+			defaultLang: "de",
+		},
+		plans: newPlanStore(path),
+	}
+}
+
+// at returns the time of day on 2026-05-1x in UTC
+func at(day, hour, minute int) time.Time {
+	return time.Date(2026, 5, day, hour, minute, 0, 0, time.UTC)
+}
+
+func proactiveChat(lastMsg time.Time) (CompleteChat, ChatMessage) {
+	msg := ChatMessage{MessageID: 1, Username: "tom", Timestamp: lastMsg.Unix()}
+	return CompleteChat{ChatId: 42, Chats: map[int]ChatMessage{1: msg}}, msg
+}
+
+func TestQuietHours(t *testing.T) {
+	tests := []struct {
+		from, to int
+		hour     int
+		want     bool
+	}{
+		{12, 14, 11, false},
+		{12, 14, 12, true},
+		{12, 14, 13, true},
+		{12, 14, 14, false},
+		{22, 7, 21, false},
+		{22, 7, 22, true},
+		{22, 7, 0, true},
+		{22, 7, 6, true},
+		{22, 7, 7, false},
+		{9, 9, 9, false},
+		{0, 0, 12, false},
+	}
+	for _, tt := range tests {
+		p := ProactivePolicy{QuietHours: QuietHours{From: tt.from, To: tt.to}}
+		if got := p.isQuiet(at(10, tt.hour, 30)); got != tt.want {
+			t.Errorf("quiet %d-%d at %d:30 = %v, want %v", tt.from, tt.to, tt.hour, got, tt.want)
+		}
+	}
+}
+
+func TestProactiveSilence(t *testing.T) {
+	p := ProactivePolicy{MinSilenceHours: 24, MaxSilenceHours: 168, Backoff: 2}
+	for unanswered, want := range []float64{24, 48, 96, 168, 168, 168} {
+		if got := p.silence(unanswered); got != time.Duration(want*float64(time.Hour)) {
+			t.Errorf("silence(%d) = %v, want %vh", unanswered, got, want)
+		}
+	}
+	if got := p.silence(1000); got != 168*time.Hour {
+		t.Errorf("silence(1000) = %v, want the max", got)
+	}
+
+	p.Backoff = 1
+	if got := p.silence(5); got != 24*time.Hour {
+		t.Errorf("silence(5) without back-off = %v, want the min", got)
+	}
+}
+
+func TestProactivePolicyCheck(t *testing.T) {
+	valid := defaultProactivePolicy()
+	if err := valid.check(); err != nil {
+		t.Fatalf("default policy: %v", err)
+	}
+
+	tests := map[string]func(p *ProactivePolicy){
+		"no min silence":    func(p *ProactivePolicy) { p.MinSilenceHours = 0 },
+		"max below min":     func(p *ProactivePolicy) { p.MaxSilenceHours = p.MinSilenceHours - 1 },
+		"negative per week": func(p *ProactivePolicy) { p.MaxPerWeek = -1 },
+		"backoff below 1":   func(p *ProactivePolicy) { p.Backoff = 0.5 },
+		"negative jitter":   func(p *ProactivePolicy) { p.JitterMinutes = -1 },
+		"quiet hour 24":     func(p *ProactivePolicy) { p.QuietHours.To = 24 },
+		"negative quiet":    func(p *ProactivePolicy) { p.QuietHours.From = -1 },
+	}
+	for name, change := range tests {
+		p := valid
+		change(&p)
+		if err := p.check(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestProactiveDue(t *testing.T) {
+	policy := ProactivePolicy{
+		QuietHours:      QuietHours{From: 12, To: 14},
+		MinSilenceHours: 24,
+		MaxSilenceHours: 72,
+		MaxPerWeek:      2,
+		Backoff:         2,
+	}
+
+	tests := []struct {
+		name   string
+		last   time.Time
+		change func(chat *CompleteChat)
+		now    time.Time
+		want   bool
+	}{
+		{"before the silence", at(10, 10, 0), nil, at(11, 9, 59), false},
+		{"after the silence", at(10, 10, 0), nil, at(11, 10, 0), true},
+		{"due in quiet hours waits", at(10, 12, 30), nil, at(11, 13, 59), false},
+		{"due in quiet hours", at(10, 12, 30), nil, at(11, 14, 0), true},
+		{"due while asleep waits", at(10, 23, 0), nil, at(11, 23, 30), false},
+		{"due while asleep next morning", at(10, 23, 0), nil, at(12, 8, 0), true},
+		{"never while asleep", at(1, 10, 0), nil, at(11, 23, 0), false},
+		{"never in quiet hours", at(1, 10, 0), nil, at(11, 12, 0), false},
+		{"back-off doubles", at(10, 10, 0), func(c *CompleteChat) { c.UnansweredProactive = 1 }, at(12, 9, 59), false},
+		{"back-off over", at(10, 10, 0), func(c *CompleteChat) { c.UnansweredProactive = 1 }, at(12, 10, 0), true},
+		{"back-off capped at max", at(10, 10, 0), func(c *CompleteChat) { c.UnansweredProactive = 5 }, at(13, 10, 0), true},
+		{"waits after the last attempt", at(10, 10, 0), func(c *CompleteChat) {
+			c.LastProactiveAttempt = at(11, 10, 0).Unix()
+		}, at(11, 18, 0), false},
+		{"weekly cap", at(10, 10, 0), func(c *CompleteChat) {
+			c.ProactiveSent = []int64{at(5, 10, 0).Unix(), at(8, 10, 0).Unix()}
+		}, at(11, 10, 0), false},
+		{"weekly cap over", at(10, 10, 0), func(c *CompleteChat) {
+			c.ProactiveSent = []int64{at(4, 9, 0).Unix(), at(8, 10, 0).Unix()}
+		}, at(11, 10, 0), true},
+	}
+	k := proactiveBot(t, policy, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat, lastMsg := proactiveChat(tt.last)
+			if tt.change != nil {
+				tt.change(&chat)
+			}
+			if got := k.proactiveDue(chat, lastMsg, tt.now); got != tt.want {
+				t.Errorf("proactiveDue at %s = %v, want %v (due at %s)",
+					tt.now.Format("02. 15:04"), got, tt.want, k.proactiveDueAt(chat, lastMsg, time.UTC).Format("02. 15:04"))
+			}
+		})
+	}
+}
+
+func TestProactiveDueOverride(t *testing.T) {
+	policy := ProactivePolicy{MinSilenceHours: 24, MaxSilenceHours: 72, MaxPerWeek: 2, Backoff: 2}
+	chat, lastMsg := proactiveChat(at(10, 10, 0))
+
+	k := proactiveBot(t, policy, `{"proactive": {"tom": {"max_per_week": 0}}}`)
+	if k.proactiveDue(chat, lastMsg, at(11, 10, 0)) {
+		t.Error("max_per_week 0 in the plans file still sends")
+	}
+
+	k = proactiveBot(t, policy, `{"proactive": {"tom": {"min_silence_hours": 100}}}`)
+	if !k.proactiveDue(chat, lastMsg, at(11, 10, 0)) {
+		t.Error("invalid override not ignored")
+	}
+}
+
+func TestProactiveJitter(t *testing.T) {
+	policy := ProactivePolicy{MinSilenceHours: 24, MaxSilenceHours: 72, MaxPerWeek: 2, Backoff: 2, JitterMinutes: 120}
+	k := proactiveBot(t, policy, "")
+	base := at(11, 10, 0)
+
+	seen := make(map[time.Time]bool)
+	for id := int64(1); id <= 50; id++ {
+		chat, lastMsg := proactiveChat(at(10, 10, 0))
+		chat.ChatId = id
+		due := k.proactiveDueAt(chat, lastMsg, time.UTC)
+		if due.Before(base) || !due.Before(base.Add(120*time.Minute)) {
+			t.Errorf("chat %d due at %s, want within 2 hours after %s", id, due, base)
+		}
+		if again := k.proactiveDueAt(chat, lastMsg, time.UTC); !again.Equal(due) {
+			t.Errorf("chat %d due at %s, then at %s", id, due, again)
+		}
+		seen[due] = true
+	}
+	if len(seen) < 10 {
+		t.Errorf("50 chats got only %d different times", len(seen))
+	}
+}
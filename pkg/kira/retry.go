@@ -0,0 +1,226 @@
+package kira
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+// retryPolicy retries transient provider errors with jittered exponential backoff
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts: 4,
+	baseDelay:   time.Second,
+	maxDelay:    30 * time.Second,
+}
+
+// delay returns how long to wait before the next attempt. A Retry-After sent
+// by the provider wins if it is longer than the backoff.
+func (p retryPolicy) delay(attempt int, err error) time.Duration {
+	backoff := p.baseDelay << attempt
+	if backoff > p.maxDelay || backoff <= 0 {
+		backoff = p.maxDelay
+	}
+	// Full jitter in the upper half so concurrent chats don't retry in lockstep
+	wait := backoff/2 + rand.N(backoff/2+1)
+
+	var transient *transientError
+	if errors.As(err, &transient) && transient.retryAfter > wait {
+		wait = transient.retryAfter
+	}
+	return wait
+}
+
+// isTransient reports errors that are worth retrying
+func isTransient(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrUnavailable)
+}
+
+// isOutage reports errors that count against the circuit breaker
+func isOutage(err error) bool {
+	return isTransient(err) || errors.Is(err, ErrTimeout)
+}
+
+// circuitBreaker stops calling a provider after repeated failures. After the
+// cooldown a single probe call is let through, its result closes or reopens it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	name      string
+	threshold int           // consecutive failures that open the breaker
+	cooldown  time.Duration // how long the breaker stays open before a probe
+	failures  int
+	open      bool
+	openedAt  time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(name string) *circuitBreaker {
+	return &circuitBreaker{name: name, threshold: 5, cooldown: 2 * time.Minute}
+}
+
+// allow reports whether a call may be made
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return true
+	}
+	if time.Since(b.openedAt) < b.cooldown || b.probing {
+		return false
+	}
+	b.probing = true
+	log.Printf("[CIRCUIT] %s half-open, sending probe", b.name)
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.open {
+		log.Printf("[CIRCUIT] %s closed, provider is back", b.name)
+	}
+	b.failures = 0
+	b.open = false
+	b.probing = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.open {
+		// The probe failed, wait another cooldown
+		b.openedAt = time.Now()
+		b.probing = false
+		log.Printf("[CIRCUIT] %s probe failed, staying open", b.name)
+		return
+	}
+	if b.failures >= b.threshold {
+		b.open = true
+		b.openedAt = time.Now()
+		log.Printf("[CIRCUIT] %s open after %d failures, pausing for %s", b.name, b.failures, b.cooldown)
+	}
+}
+
+// isOpen reports whether the provider is considered down
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// generateContent calls the model through the circuit breaker and retries
+// transient errors until the attempts or the context run out.
+func (k *KiraBot) generateContent(ctx context.Context, model *genai.GenerativeModel, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	var resp *genai.GenerateContentResponse
+	err := k.callWithRetry(ctx, func() (bool, error) {
+		var err error
+		resp, err = model.GenerateContent(ctx, parts...)
+		return false, err
+	})
+	return resp, err
+}
+
+// generateContentStream streams the model's reply and passes every text part
+// to onText as it arrives. It returns the merged response. Once text was
+// passed on, a failed stream is not retried.
+func (k *KiraBot) generateContentStream(ctx context.Context, model *genai.GenerativeModel, onText func(string), parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	var merged *genai.GenerateContentResponse
+	err := k.callWithRetry(ctx, func() (bool, error) {
+		iter := model.GenerateContentStream(ctx, parts...)
+		var usage *genai.UsageMetadata
+		received := false
+		for {
+			resp, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				merged = iter.MergedResponse()
+				return received, err
+			}
+			if resp.UsageMetadata != nil {
+				usage = resp.UsageMetadata
+			}
+			if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+				continue
+			}
+			for _, part := range resp.Candidates[0].Content.Parts {
+				if text, ok := part.(genai.Text); ok {
+					received = true
+					onText(string(text))
+				}
+			}
+		}
+
+		// genai doesn't merge the usage, the last chunk carries the totals
+		merged = iter.MergedResponse()
+		if merged != nil {
+			merged.UsageMetadata = usage
+		}
+		return received, nil
+	})
+	return merged, err
+}
+
+// callWithRetry runs call through the circuit breaker and retries transient
+// errors until the attempts or the context run out. call reports whether its
+// output was already used, then it isn't retried.
+func (k *KiraBot) callWithRetry(ctx context.Context, call func() (consumed bool, err error)) error {
+	var lastErr error
+	for attempt := 0; attempt < defaultRetryPolicy.maxAttempts; attempt++ {
+		if !k.breaker.allow() {
+			if lastErr != nil {
+				return fmt.Errorf("%w (last error: %v)", ErrCircuitOpen, lastErr)
+			}
+			return ErrCircuitOpen
+		}
+
+		consumed, err := call()
+		if err == nil {
+			k.breaker.success()
+			return nil
+		}
+
+		err = geminiError(err)
+		if !isOutage(err) {
+			// The provider answered, e.g. with a block, so it is up
+			k.breaker.success()
+			return err
+		}
+		k.breaker.failure()
+		lastErr = err
+
+		if consumed || !isTransient(err) || attempt == defaultRetryPolicy.maxAttempts-1 {
+			break
+		}
+
+		wait := defaultRetryPolicy.delay(attempt, err)
+		log.Printf("Transient error from %s (attempt %d/%d), retrying in %s: %v",
+			k.breaker.name, attempt+1, defaultRetryPolicy.maxAttempts, wait.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: gave up retrying: %v", ErrTimeout, lastErr)
+		case <-timer.C:
+		}
+	}
+	return lastErr
+}
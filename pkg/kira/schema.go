@@ -0,0 +1,171 @@
+package kira
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// schemaOf generates the response schema for structured output from a Go
+// type, so the schema can't drift from the struct the answer is decoded into.
+//
+// Field names come from the json tags, fields tagged "-" are skipped.
+// Optional tags on a field:
+//
+//	desc:"..."      description for the model
+//	enum:"a,b,c"    allowed values of a string field
+//	required:"true" the model has to fill the field
+func schemaOf[T any]() *genai.Schema {
+	schema, err := schemaForType(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		// The types are fixed at compile time, this is a programming error
+		panic(fmt.Sprintf("schemaOf: %v", err))
+	}
+	return schema
+}
+
+func schemaForType(t reflect.Type) (*genai.Schema, error) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema, err := schemaForType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		schema.Nullable = true
+		return schema, nil
+	case reflect.String:
+		return &genai.Schema{Type: genai.TypeString}, nil
+	case reflect.Bool:
+		return &genai.Schema{Type: genai.TypeBoolean}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &genai.Schema{Type: genai.TypeInteger}, nil
+	case reflect.Float32, reflect.Float64:
+		return &genai.Schema{Type: genai.TypeNumber}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := schemaForType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &genai.Schema{Type: genai.TypeArray, Items: items}, nil
+	case reflect.Struct:
+		return schemaForStruct(t)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+func schemaForStruct(t reflect.Type) (*genai.Schema, error) {
+	schema := &genai.Schema{Type: genai.TypeObject, Properties: make(map[string]*genai.Schema)}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property, err := schemaForType(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), field.Name, err)
+		}
+		if desc := field.Tag.Get("desc"); desc != "" {
+			property.Description = desc
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			if property.Type != genai.TypeString {
+				return nil, fmt.Errorf("%s.%s: enum on a non-string field", t.Name(), field.Name)
+			}
+			property.Format = "enum"
+			property.Enum = strings.Split(enum, ",")
+		}
+		if field.Tag.Get("required") == "true" {
+			schema.Required = append(schema.Required, name)
+		}
+
+		schema.Properties[name] = property
+	}
+	return schema, nil
+}
+
+// decodeStructured decodes a structured model answer into T. The answer is
+// checked against the schema first, so missing required fields and values
+// outside an enum are errors instead of silently becoming zero values.
+func decodeStructured[T any](schema *genai.Schema, text string) (T, error) {
+	var result T
+
+	text = strings.TrimSpace(text)
+	// Some models wrap the JSON in a markdown code block despite the MIME type
+	if after, ok := strings.CutPrefix(text, "```json"); ok {
+		text = strings.TrimSpace(after)
+	}
+	if before, ok := strings.CutSuffix(text, "```"); ok {
+		text = strings.TrimSpace(before)
+	}
+
+	var raw any
+	if err := json.Unmarshal([]byte(text), &raw); err != nil {
+		return result, err
+	}
+	if err := validateSchema(schema, raw, "$"); err != nil {
+		return result, err
+	}
+	if err := json.Unmarshal([]byte(text), &result); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// validateSchema checks a decoded JSON value against the parts of the schema
+// that encoding/json doesn't: required fields and enums
+func validateSchema(schema *genai.Schema, value any, path string) error {
+	if value == nil {
+		return nil
+	}
+	switch schema.Type {
+	case genai.TypeObject:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required field %q", path, name)
+			}
+		}
+		for name, property := range schema.Properties {
+			if err := validateSchema(property, obj[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case genai.TypeArray:
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+		for i, item := range items {
+			if err := validateSchema(schema.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case genai.TypeString:
+		if len(schema.Enum) == 0 {
+			return nil
+		}
+		if str, ok := value.(string); !ok || !slices.Contains(schema.Enum, str) {
+			return fmt.Errorf("%s: %v is not one of %s", path, value, strings.Join(schema.Enum, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package kira
+
+import (
+	"testing"
+)
+
+type schemaTestItem struct {
+	Kind string `json:"kind" enum:"a,b" required:"true"`
+	Note string `json:"note"`
+}
+
+type schemaTestForm struct {
+	Name  string           `json:"name" required:"true"`
+	Items []schemaTestItem `json:"items"`
+	Mood  *string          `json:"mood" enum:"happy,sad"`
+}
+
+func TestDecodeStructured(t *testing.T) {
+	schema := schemaOf[schemaTestForm]()
+	tests := []struct {
+		name    string
+		text    string
+		want    string // Name of the decoded form
+		wantErr bool
+	}{
+		{"valid", `{"name": "Kira", "items": [{"kind": "a"}], "mood": "happy"}`, "Kira", false},
+		{"optional fields missing", `{"name": "Kira"}`, "Kira", false},
+		{"null enum", `{"name": "Kira", "mood": null}`, "Kira", false},
+		{"code block", "```json\n{\"name\": \"Kira\"}\n```", "Kira", false},
+		{"missing required", `{"items": []}`, "", true},
+		{"missing required in item", `{"name": "Kira", "items": [{"note": "x"}]}`, "", true},
+		{"enum in item", `{"name": "Kira", "items": [{"kind": "c"}]}`, "", true},
+		{"enum", `{"name": "Kira", "mood": "angry"}`, "", true},
+		{"object expected", `{"name": "Kira", "items": ["a"]}`, "", true},
+		{"array expected", `{"name": "Kira", "items": {"kind": "a"}}`, "", true},
+		{"wrong type", `{"name": 5}`, "", true},
+		{"not json", `name: Kira`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeStructured[schemaTestForm](schema, tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.Name != tt.want {
+				t.Errorf("name = %q, want %q", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSchemaPath(t *testing.T) {
+	schema := schemaOf[schemaTestForm]()
+	value := map[string]any{
+		"name":  "Kira",
+		"items": []any{map[string]any{"kind": "a"}, map[string]any{"kind": "x"}},
+	}
+	err := validateSchema(schema, value, "$")
+	if err == nil {
+		t.Fatal("want an error for the enum")
+	}
+	if want := `$.items[1].kind: x is not one of a, b`; err.Error() != want {
+		t.Errorf("err = %q, want %q", err, want)
+	}
+}
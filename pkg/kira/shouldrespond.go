@@ -6,11 +6,13 @@ import (
 	"time"
 )
 
-// shouldRespondToMessage determines if we should respond to a message
-func (k *KiraBot) shouldRespondToMessage(lastMsg ChatMessage, lastMessages []ChatMessage) (shouldRespond bool, shouldEngageWithExtraStory bool) {
+// shouldRespondToMessage determines if we should respond to a message,
+// now is the current time in the chat's time zone. When the chat went quiet
+// the proactive scheduler decides, see proactive.go.
+func (k *KiraBot) shouldRespondToMessage(chat CompleteChat, now time.Time, lastMsg ChatMessage, lastMessages []ChatMessage) (shouldRespond bool, shouldEngageWithExtraStory bool) {
 	log.Println("Should Respond?")
+	persona := k.personaFor(chat)
 
-	now := time.Now()
 	lastMsgTime, err := time.ParseInLocation("2006-01-02 15:04:05", lastMsg.Date, time.Local)
 
 	if err != nil {
@@ -23,17 +25,21 @@ func (k *KiraBot) shouldRespondToMessage(lastMsg ChatMessage, lastMessages []Cha
 	// if the last message is fresh still respond
 	timeSinceLastMsg := now.Sub(lastMsgTime)
 	if timeSinceLastMsg < 5*time.Minute && !lastMsg.IsBot && !lastMsg.ShouldNotRespond {
+		// Short messages in a row are answered together once the user stopped writing
+		if k.bursts.waiting(lastMessages, now) {
+			log.Printf("User of chat %d may still be writing, waiting", chat.ChatId)
+			return false, false
+		}
 		log.Printf("Fresh Message")
 		return true, false
 	}
-	hour := now.Hour()
 	// Check if there was fast chatting.
 	if lastMsg.IsBot && !lastMsg.ShouldNotRespond {
 		log.Println("last msgbot")
-		//TODO: Check if msgs are extremly fresh shorter than typing time.
-		//Happens if user and bot write in between.
-		if timeSinceLastMsg > 24*time.Hour && hour > 10 && hour <= 22 {
-			log.Printf("Old message - Provide extra story")
+		// A user message written while a reply is typed stops the reply, see
+		// burst.go. Chats from before that can still have them in between.
+		if k.proactiveDue(chat, lastMsg, now) {
+			log.Printf("Proactive message due - Provide extra story")
 			return true, true
 		}
 		for i := 2; i < 6; i++ {
@@ -41,6 +47,11 @@ func (k *KiraBot) shouldRespondToMessage(lastMsg ChatMessage, lastMessages []Cha
 				break
 			}
 			if !lastMessages[len(lastMessages)-i].IsBot {
+				if lastMessages[len(lastMessages)-i].ShouldNotRespond {
+					// Answered by the crisis hotlines or a refusal, the persona stays out of it
+					log.Println("user msg already handled")
+					return false, false
+				}
 				timeDiff := math.Abs(float64(lastMsg.Timestamp) - float64(lastMessages[len(lastMessages)-i].Timestamp))
 				if timeDiff > 30 {
 					log.Println("was read")
@@ -57,15 +68,14 @@ func (k *KiraBot) shouldRespondToMessage(lastMsg ChatMessage, lastMessages []Cha
 		return false, false
 	}
 
-	// If it is before 10:00 and after 22:00 return false as Kira sleeps
-
-	if hour < 10 || hour >= 22 {
+	// Outside the waking hours the persona sleeps
+	if !persona.isAwake(now) {
 		return false, false
 	}
 
-	// If the last message is longer than 24h return true with extra story to engage
-	if timeSinceLastMsg > 24*time.Hour {
-		log.Printf("Old message - Provide extra story")
+	// If the chat went quiet reach out with an extra story when the scheduler says so
+	if k.proactiveDue(chat, lastMsg, now) {
+		log.Printf("Proactive message due - Provide extra story")
 		return true, true
 	}
 
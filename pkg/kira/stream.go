@@ -0,0 +1,157 @@
+package kira
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// minStreamChunk is how much of the reply, in complete sentences, the persona
+// knows before she starts typing, so a short "Haha." doesn't count yet
+const minStreamChunk = 40
+
+var (
+	respondFieldRegex = regexp.MustCompile(`"respond"\s*:\s*(true|false)`)
+	messageFieldRegex = regexp.MustCompile(`"text"\s*:\s*"`)
+	sentenceEndRegex  = regexp.MustCompile(`[.!?…]+["')]?\s+|\n+`)
+)
+
+// messageExtractor pulls the message out of the structured talk response
+// while it is still being streamed. Nothing is returned before "respond" is
+// true, the persona doesn't start typing a message she decided not to send.
+type messageExtractor struct {
+	raw     string
+	respond bool
+	pos     int // next unread byte of the message value, -1 until the field starts
+	closed  bool
+}
+
+func newMessageExtractor() *messageExtractor {
+	return &messageExtractor{pos: -1}
+}
+
+// write adds streamed output and returns the newly decoded message text
+func (e *messageExtractor) write(chunk string) string {
+	e.raw += chunk
+	if e.closed {
+		return ""
+	}
+	if !e.respond {
+		m := respondFieldRegex.FindStringSubmatch(e.raw)
+		if m == nil {
+			// The message may come first, it waits for the decision
+			return ""
+		}
+		if m[1] != "true" {
+			e.closed = true
+			return ""
+		}
+		e.respond = true
+	}
+	if e.pos < 0 {
+		loc := messageFieldRegex.FindStringIndex(e.raw)
+		if loc == nil {
+			return ""
+		}
+		e.pos = loc[1]
+	}
+
+	var out strings.Builder
+	for e.pos < len(e.raw) {
+		switch e.raw[e.pos] {
+		case '"':
+			e.closed = true
+			return out.String()
+		case '\\':
+			n := escapeLen(e.raw[e.pos:])
+			if n == 0 {
+				// The escape sequence is not complete yet
+				return out.String()
+			}
+			var decoded string
+			if err := json.Unmarshal([]byte(`"`+e.raw[e.pos:e.pos+n]+`"`), &decoded); err == nil {
+				out.WriteString(decoded)
+			}
+			e.pos += n
+		default:
+			end := strings.IndexAny(e.raw[e.pos:], `"\`)
+			if end < 0 {
+				end = len(e.raw) - e.pos
+			}
+			out.WriteString(e.raw[e.pos : e.pos+end])
+			e.pos += end
+		}
+	}
+	return out.String()
+}
+
+// escapeLen returns the length of the JSON escape sequence at the start of s,
+// 0 if it is incomplete
+func escapeLen(s string) int {
+	if len(s) < 2 {
+		return 0
+	}
+	if s[1] != 'u' {
+		return 2
+	}
+	if len(s) < 6 {
+		return 0
+	}
+	r, err := strconv.ParseUint(s[2:6], 16, 32)
+	if err != nil || r < 0xD800 || r >= 0xDC00 {
+		return 6
+	}
+	// High surrogate, the low one follows
+	if len(s) < 12 {
+		return 0
+	}
+	if s[6:8] == `\u` {
+		return 12
+	}
+	return 6
+}
+
+// sentenceEnd returns the end of the first complete sentences of at least
+// minStreamChunk characters in text, -1 if there are none yet
+func sentenceEnd(text string) int {
+	for _, loc := range sentenceEndRegex.FindAllStringIndex(text, -1) {
+		if utf8.RuneCountInString(text[:loc[1]]) >= minStreamChunk {
+			return loc[1]
+		}
+	}
+	return -1
+}
+
+// replyStream follows a reply while the model is still generating it. Nothing
+// is sent before the whole reply passed the guardrails and the outbound
+// moderation, but the persona starts typing as soon as her first sentence is
+// written, so the typing delay of the first message overlaps with the
+// generation.
+type replyStream struct {
+	k           *KiraBot
+	chatID      int64
+	extractor   *messageExtractor
+	text        string    // the message so far
+	typingSince time.Time // zero until the first sentence is written
+}
+
+func (k *KiraBot) newReplyStream(chatID int64) *replyStream {
+	return &replyStream{k: k, chatID: chatID, extractor: newMessageExtractor()}
+}
+
+// write takes streamed model output
+func (s *replyStream) write(chunk string) {
+	s.text += s.extractor.write(chunk)
+	if s.typingSince.IsZero() && sentenceEnd(s.text) >= 0 {
+		s.typingSince = time.Now()
+		s.k.sendTypingAction(s.chatID)
+	}
+}
+
+// raw returns the complete model output
+func (s *replyStream) raw() string {
+	return s.extractor.raw
+}
@@ -0,0 +1,111 @@
+package kira
+
+import (
+	"strings"
+	"testing"
+)
+
+// extract feeds chunks to a new extractor and returns everything it emitted
+func extract(chunks ...string) (string, *messageExtractor) {
+	e := newMessageExtractor()
+	var out strings.Builder
+	for _, c := range chunks {
+		out.WriteString(e.write(c))
+	}
+	return out.String(), e
+}
+
+func TestMessageExtractor(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"one chunk", []string{`{"respond": true, "text": "Hallo du!"}`}, "Hallo du!"},
+		{"split everywhere", []string{`{"resp`, `ond": tr`, `ue, "te`, `xt": "Hal`, `lo du`, `!"}`}, "Hallo du!"},
+		{"respond false", []string{`{"respond": false, "text": "Hallo"}`}, ""},
+		{"respond false split", []string{`{"respond": fa`, `lse, "text": "Hal`, `lo"}`}, ""},
+		{"text before respond", []string{`{"text": "Hallo", `, `"respond": true}`}, "Hallo"},
+		{"text before respond false", []string{`{"text": "Hallo", `, `"respond": false}`}, ""},
+		{"no respond yet", []string{`{"text": "Hallo"`}, ""},
+		{"escaped quote", []string{`{"respond": true, "text": "Sie sagte \"hi\" und ging"}`}, `Sie sagte "hi" und ging`},
+		{"split escape", []string{`{"respond": true, "text": "Zeile 1\`, `nZeile 2"}`}, "Zeile 1\nZeile 2"},
+		{"unicode escape", []string{`{"respond": true, "text": "sch\u00f6n"}`}, "schön"},
+		{"split unicode escape", []string{`{"respond": true, "text": "sch\u00`, `f6n"}`}, "schön"},
+		{"surrogate pair", []string{`{"respond": true, "text": "hey \ud83d\ude00"}`}, "hey 😀"},
+		{"split surrogate pair", []string{`{"respond": true, "text": "hey \ud83d`, `\ude00!"}`}, "hey 😀!"},
+		{"raw umlauts", []string{`{"respond": true, "text": "Grüße 🙂"}`}, "Grüße 🙂"},
+		{"stops at the closing quote", []string{`{"respond": true, "text": "Hi"`, `, "extra": "nope"}`}, "Hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := extract(tt.chunks...)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageExtractorKeepsRaw(t *testing.T) {
+	chunks := []string{`{"respond": false, `, `"text": ""}`}
+	_, e := extract(chunks...)
+	if e.raw != strings.Join(chunks, "") {
+		t.Errorf("raw = %q", e.raw)
+	}
+	result, err := parseTalkResponse(e.raw)
+	if err != nil || result.Outcome != TalkSilence {
+		t.Errorf("parseTalkResponse(raw) = %+v, %v", result, err)
+	}
+}
+
+func TestEscapeLen(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{`\`, 0},
+		{`\n`, 2},
+		{`\"rest`, 2},
+		{`\u00`, 0},
+		{`\u00f6`, 6},
+		{`\u00f6abc`, 6},
+		{`\ud83d`, 0},
+		{`\ud83d\ude`, 0},
+		{`\ud83d\ude00`, 12},
+		{`\ud83dabcdef`, 6},
+		{`\uzzzz`, 6},
+	}
+	for _, tt := range tests {
+		if got := escapeLen(tt.s); got != tt.want {
+			t.Errorf("escapeLen(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSentenceEnd(t *testing.T) {
+	long := "Das war heute ein wirklich langer Tag im Büro."
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", -1},
+		{"sentence not finished", long, -1},
+		{"sentence finished", long + " Und", len(long) + 1},
+		{"short sentences add up", "Haha. Echt jetzt? Das ist ja witzig, erzähl! Mehr", len("Haha. Echt jetzt? Das ist ja witzig, erzähl! ")},
+		{"short sentence alone", "Haha. Echt", -1},
+		{"newline", strings.Repeat("a", 40) + "\n\nb", 42},
+		{"ellipsis", strings.Repeat("a", 40) + "… b", 40 + len("… ")},
+		{"quote after the end", strings.Repeat("a", 40) + `!" b`, 43},
+		{"counts characters", strings.Repeat("ö", 39) + ". x", len(strings.Repeat("ö", 39)) + 2},
+		{"no space after the dot", strings.Repeat("a", 40) + ".b", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sentenceEnd(tt.text); got != tt.want {
+				t.Errorf("sentenceEnd(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package kira
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TalkOutcome is what came out of asking the model for the next reply
+type TalkOutcome int
+
+const (
+	TalkReply         TalkOutcome = iota // Text holds the reply
+	TalkSilence                          // the model decided not to answer
+	TalkBlocked                          // the provider blocked the request or the reply
+	TalkTimeout                          // the request took too long
+	TalkLimitReached                     // a message limit or token budget is used up
+	TalkProviderError                    // any other error of the provider
+)
+
+func (o TalkOutcome) String() string {
+	switch o {
+	case TalkReply:
+		return "reply"
+	case TalkSilence:
+		return "silence"
+	case TalkBlocked:
+		return "blocked"
+	case TalkTimeout:
+		return "timeout"
+	case TalkLimitReached:
+		return "limit_reached"
+	case TalkProviderError:
+		return "provider_error"
+	default:
+		return fmt.Sprintf("TalkOutcome(%d)", int(o))
+	}
+}
+
+// TalkResult is the typed result of a talk call
+type TalkResult struct {
+	Outcome     TalkOutcome
+	Text        string    // only set for TalkReply
+	TypingSince time.Time // when the persona started typing the reply while it was generated, zero if she didn't
+	Variant     string    // experiment/variant of the prompt, empty outside experiments
+	Err         error     // set for TalkBlocked, TalkTimeout, TalkLimitReached and TalkProviderError
+}
+
+// talkResponse is the structured output the model answers with. The SDK
+// can't set a property order and Gemini orders them alphabetically, the
+// message is "text" so respond comes first and the stream knows the
+// decision before the message starts.
+type talkResponse struct {
+	Respond bool   `json:"respond" required:"true" desc:"false if the persona doesn't answer right now"`
+	Message string `json:"text" required:"true" desc:"the persona's next chat message, empty if respond is false"`
+}
+
+// talkResponseSchema makes the model answer with a talkResponse
+var talkResponseSchema = schemaOf[talkResponse]()
+
+func replyResult(text string) TalkResult {
+	return TalkResult{Outcome: TalkReply, Text: text}
+}
+
+func silenceResult() TalkResult {
+	return TalkResult{Outcome: TalkSilence}
+}
+
+// parseTalkResponse turns the model output into a reply or silence
+func parseTalkResponse(raw string) (TalkResult, error) {
+	resp, err := decodeStructured[talkResponse](talkResponseSchema, raw)
+	if err != nil {
+		return TalkResult{}, fmt.Errorf("failed to decode talk response: %v", err)
+	}
+
+	message := strings.TrimSpace(resp.Message)
+	if !resp.Respond || message == "" {
+		return silenceResult(), nil
+	}
+	return replyResult(message), nil
+}
+
+// talkErrorResult classifies an error of a talk call
+func talkErrorResult(err error) TalkResult {
+	switch {
+	case errors.Is(err, ErrQuota):
+		return TalkResult{Outcome: TalkLimitReached, Err: err}
+	case errors.Is(err, ErrBlocked):
+		return TalkResult{Outcome: TalkBlocked, Err: err}
+	case errors.Is(err, ErrTimeout):
+		return TalkResult{Outcome: TalkTimeout, Err: err}
+	default:
+		return TalkResult{Outcome: TalkProviderError, Err: err}
+	}
+}
@@ -0,0 +1,64 @@
+package kira
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParseTalkResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    TalkResult
+		wantErr bool
+	}{
+		{"reply", `{"respond": true, "text": "Hi!"}`, replyResult("Hi!"), false},
+		{"reply is trimmed", `{"respond": true, "text": "  Hi!\n"}`, replyResult("Hi!"), false},
+		{"code block", "```json\n{\"respond\": true, \"text\": \"Hi!\"}\n```", replyResult("Hi!"), false},
+		{"silence", `{"respond": false, "text": ""}`, silenceResult(), false},
+		{"text without respond", `{"respond": false, "text": "Hi!"}`, silenceResult(), false},
+		{"empty text", `{"respond": true, "text": "   "}`, silenceResult(), false},
+		{"missing respond", `{"text": "Hi!"}`, TalkResult{}, true},
+		{"missing text", `{"respond": true}`, TalkResult{}, true},
+		{"not json", `Hi!`, TalkResult{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTalkResponse(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTalkErrorResult(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want TalkOutcome
+	}{
+		{"limit", &limitError{reason: "daily message limit of 30"}, TalkLimitReached},
+		{"quota", fmt.Errorf("talk: %w", ErrQuota), TalkLimitReached},
+		{"blocked", &BlockedError{Reason: "reply FinishReasonSafety"}, TalkBlocked},
+		{"wrapped blocked", fmt.Errorf("stream: %w", &BlockedError{Reason: "prompt"}), TalkBlocked},
+		{"timeout", fmt.Errorf("%w: context deadline exceeded", ErrTimeout), TalkTimeout},
+		{"unavailable", &transientError{kind: ErrUnavailable, err: errors.New("503")}, TalkProviderError},
+		{"other", errors.New("boom"), TalkProviderError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := talkErrorResult(tt.err)
+			if got.Outcome != tt.want {
+				t.Errorf("outcome = %v, want %v", got.Outcome, tt.want)
+			}
+			if got.Err != tt.err {
+				t.Errorf("err = %v, want %v", got.Err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package kira
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // zones work on hosts without a zoneinfo database
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"gitea.karlbreuer.com/karl1b/kira/pkg/settings"
+)
+
+// locations caches loaded time zones by name
+var locations sync.Map
+
+// loadLocation loads a time zone, the empty name is the default zone
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		name = settings.Settings.Timezone
+		if name == "" {
+			return time.Local, nil
+		}
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// locationFor returns the time zone of a chat. Waking hours, proactive
+// messages, the daily limits and token budgets and the time in the prompt
+// are in this zone.
+func (k *KiraBot) locationFor(chat CompleteChat) *time.Location {
+	loc, err := loadLocation(chat.Timezone)
+	if err != nil {
+		log.Printf("Invalid time zone %q of chat %d, using the default: %v", chat.Timezone, chat.ChatId, err)
+		if loc, err = loadLocation(""); err != nil {
+			return time.Local
+		}
+	}
+	return loc
+}
+
+// nowFor returns the current time in the zone of a chat
+func (k *KiraBot) nowFor(chat CompleteChat) time.Time {
+	return time.Now().In(k.locationFor(chat))
+}
+
+// setChatTimezone stores the time zone of a chat. It takes k.mu.
+func (k *KiraBot) setChatTimezone(chatID int64, name string) error {
+	if _, err := loadLocation(name); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	chat, exists := k.chats[chatID]
+	if !exists {
+		k.mu.Unlock()
+		return fmt.Errorf("chat %d not found", chatID)
+	}
+	chat.Timezone = name
+	k.chats[chatID] = chat
+	k.mu.Unlock()
+
+	log.Printf("Chat %d is in time zone %s", chatID, name)
+	return k.saveChatState(chat)
+}
+
+// handleTimezoneCommand answers /timezone: without an argument it tells the
+// current zone, with one it sets it. The command isn't part of the chat.
+func (k *KiraBot) handleTimezoneCommand(message *tgbotapi.Message) {
+	lang := k.languageForMessage(message.Chat.ID, message.From.UserName, message.From.LanguageCode)
+
+	k.mu.Lock()
+	chat, exists := k.chats[message.Chat.ID]
+	if !exists {
+		// The command can come before the first message, the chat starts here
+		chat = CompleteChat{ChatId: message.Chat.ID, Chats: make(map[int]ChatMessage)}
+		k.setupNewChat(&chat, ChatMessage{ChatID: message.Chat.ID, Username: message.From.UserName, LanguageCode: message.From.LanguageCode})
+		k.chats[message.Chat.ID] = chat
+	}
+	k.mu.Unlock()
+
+	name := strings.TrimSpace(message.CommandArguments())
+	if name == "" {
+		loc := k.locationFor(chat)
+		k.sendMessage(message.Chat.ID, fmt.Sprintf(lang.Text.TimezoneCurrent, loc, time.Now().In(loc).Format("15:04")), "")
+		return
+	}
+
+	if err := k.setChatTimezone(message.Chat.ID, name); err != nil {
+		log.Printf("Invalid time zone %q for chat %d: %v", name, message.Chat.ID, err)
+		k.sendMessage(message.Chat.ID, lang.Text.TimezoneInvalid, "")
+		return
+	}
+	k.sendMessage(message.Chat.ID, fmt.Sprintf(lang.Text.TimezoneSet, name), "")
+}
+
+// timezoneFromLocation sets the time zone of a chat from a location the user
+// shared. It is the zone of the nearest city in zoneCities, close to a border
+// that can be the neighbour's, /timezone corrects it.
+func (k *KiraBot) timezoneFromLocation(chatID int64, lat, lon float64) {
+	name := zoneForCoordinates(lat, lon)
+
+	k.mu.Lock()
+	current := k.chats[chatID].Timezone
+	k.mu.Unlock()
+	if name == current {
+		return
+	}
+
+	if err := k.setChatTimezone(chatID, name); err != nil {
+		log.Printf("Error setting time zone of chat %d from location: %v", chatID, err)
+	}
+}
+
+// zoneForCoordinates returns the zone of the city in zoneCities nearest to the coordinates
+func zoneForCoordinates(lat, lon float64) string {
+	best, bestDist := "", math.Inf(1)
+	for _, c := range zoneCities {
+		// Equirectangular distance, good enough to pick the nearest city
+		dLon := math.Abs(lon - c.lon)
+		if dLon > 180 {
+			dLon = 360 - dLon
+		}
+		x := dLon * math.Cos((lat+c.lat)/2*math.Pi/180)
+		y := lat - c.lat
+		if dist := x*x + y*y; dist < bestDist {
+			best, bestDist = c.zone, dist
+		}
+	}
+	return best
+}
+
+// localizeMessages returns a copy of messages with the dates in loc, so the
+// model sees them in the same zone as the current time
+func localizeMessages(messages []ChatMessage, loc *time.Location) []ChatMessage {
+	localized := make([]ChatMessage, len(messages))
+	for i, msg := range messages {
+		if msg.Timestamp != 0 {
+			msg.Date = time.Unix(msg.Timestamp, 0).In(loc).Format("2006-01-02 15:04:05")
+		}
+		localized[i] = msg
+	}
+	return localized
+}
+
+// zoneCities are one or more cities per time zone to find the zone of a location
+var zoneCities = []struct {
+	zone     string
+	lat, lon float64
+}{
+	// Europe
+	{"Europe/Berlin", 52.52, 13.40},
+	{"Europe/Berlin", 48.14, 11.58},
+	{"Europe/Berlin", 53.55, 9.99},
+	{"Europe/Berlin", 50.94, 6.96},
+	{"Europe/Vienna", 48.21, 16.37},
+	{"Europe/Vienna", 47.07, 15.44},
+	{"Europe/Zurich", 47.38, 8.54},
+	{"Europe/Zurich", 46.20, 6.14},
+	{"Europe/Amsterdam", 52.37, 4.90},
+	{"Europe/Brussels", 50.85, 4.35},
+	{"Europe/Luxembourg", 49.61, 6.13},
+	{"Europe/Paris", 48.86, 2.35},
+	{"Europe/Paris", 43.30, 5.37},
+	{"Europe/London", 51.51, -0.13},
+	{"Europe/London", 55.95, -3.19},
+	{"Europe/Dublin", 53.35, -6.26},
+	{"Europe/Lisbon", 38.72, -9.14},
+	{"Europe/Madrid", 40.42, -3.70},
+	{"Europe/Madrid", 41.39, 2.17},
+	{"Atlantic/Canary", 28.12, -15.43},
+	{"Europe/Rome", 41.90, 12.50},
+	{"Europe/Rome", 45.46, 9.19},
+	{"Europe/Copenhagen", 55.68, 12.57},
+	{"Europe/Oslo", 59.91, 10.75},
+	{"Europe/Stockholm", 59.33, 18.07},
+	{"Europe/Helsinki", 60.17, 24.94},
+	{"Europe/Tallinn", 59.44, 24.75},
+	{"Europe/Riga", 56.95, 24.11},
+	{"Europe/Vilnius", 54.69, 25.28},
+	{"Europe/Warsaw", 52.23, 21.01},
+	{"Europe/Prague", 50.08, 14.44},
+	{"Europe/Bratislava", 48.15, 17.11},
+	{"Europe/Budapest", 47.50, 19.04},
+	{"Europe/Ljubljana", 46.06, 14.51},
+	{"Europe/Zagreb", 45.81, 15.98},
+	{"Europe/Belgrade", 44.79, 20.45},
+	{"Europe/Bucharest", 44.43, 26.10},
+	{"Europe/Sofia", 42.70, 23.32},
+	{"Europe/Athens", 37.98, 23.73},
+	{"Europe/Istanbul", 41.01, 28.98},
+	{"Europe/Kyiv", 50.45, 30.52},
+	{"Europe/Minsk", 53.90, 27.56},
+	{"Europe/Moscow", 55.76, 37.62},
+	{"Atlantic/Reykjavik", 64.15, -21.94},
+	// Africa and Middle East
+	{"Africa/Casablanca", 33.57, -7.59},
+	{"Africa/Lagos", 6.52, 3.38},
+	{"Africa/Cairo", 30.04, 31.24},
+	{"Africa/Nairobi", -1.29, 36.82},
+	{"Africa/Johannesburg", -26.20, 28.05},
+	{"Asia/Jerusalem", 31.77, 35.21},
+	{"Asia/Riyadh", 24.71, 46.68},
+	{"Asia/Dubai", 25.20, 55.27},
+	{"Asia/Tehran", 35.69, 51.39},
+	// Asia and Oceania
+	{"Asia/Karachi", 24.86, 67.01},
+	{"Asia/Kolkata", 28.61, 77.21},
+	{"Asia/Kolkata", 19.08, 72.88},
+	{"Asia/Dhaka", 23.81, 90.41},
+	{"Asia/Bangkok", 13.76, 100.50},
+	{"Asia/Ho_Chi_Minh", 10.82, 106.63},
+	{"Asia/Jakarta", -6.21, 106.85},
+	{"Asia/Singapore", 1.35, 103.82},
+	{"Asia/Manila", 14.60, 120.98},
+	{"Asia/Shanghai", 31.23, 121.47},
+	{"Asia/Shanghai", 39.90, 116.41},
+	{"Asia/Hong_Kong", 22.32, 114.17},
+	{"Asia/Taipei", 25.03, 121.57},
+	{"Asia/Seoul", 37.57, 126.98},
+	{"Asia/Tokyo", 35.68, 139.69},
+	{"Australia/Perth", -31.95, 115.86},
+	{"Australia/Adelaide", -34.93, 138.60},
+	{"Australia/Brisbane", -27.47, 153.03},
+	{"Australia/Sydney", -33.87, 151.21},
+	{"Australia/Melbourne", -37.81, 144.96},
+	{"Pacific/Auckland", -36.85, 174.76},
+	// Americas
+	{"America/St_Johns", 47.56, -52.71},
+	{"America/Halifax", 44.65, -63.58},
+	{"America/Toronto", 43.65, -79.38},
+	{"America/New_York", 40.71, -74.01},
+	{"America/New_York", 38.91, -77.04},
+	{"America/New_York", 25.76, -80.19},
+	{"America/Chicago", 41.88, -87.63},
+	{"America/Chicago", 29.76, -95.37},
+	{"America/Winnipeg", 49.90, -97.14},
+	{"America/Denver", 39.74, -104.99},
+	{"America/Edmonton", 53.55, -113.49},
+	{"America/Phoenix", 33.45, -112.07},
+	{"America/Los_Angeles", 34.05, -118.24},
+	{"America/Los_Angeles", 37.77, -122.42},
+	{"America/Los_Angeles", 47.61, -122.33},
+	{"America/Vancouver", 49.28, -123.12},
+	{"America/Anchorage", 61.22, -149.90},
+	{"Pacific/Honolulu", 21.31, -157.86},
+	{"America/Mexico_City", 19.43, -99.13},
+	{"America/Bogota", 4.71, -74.07},
+	{"America/Lima", -12.05, -77.04},
+	{"America/Santiago", -33.45, -70.67},
+	{"America/Argentina/Buenos_Aires", -34.60, -58.38},
+	{"America/Sao_Paulo", -23.55, -46.63},
+}
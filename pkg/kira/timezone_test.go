@@ -0,0 +1,29 @@
+package kira
+
+import "testing"
+
+func TestZoneForCoordinates(t *testing.T) {
+	tests := []struct {
+		name     string
+		lat, lon float64
+		want     string
+	}{
+		{"Berlin", 52.52, 13.40, "Europe/Berlin"},
+		{"Potsdam", 52.39, 13.06, "Europe/Berlin"},
+		{"Paris", 48.86, 2.35, "Europe/Paris"},
+		{"London", 51.50, -0.12, "Europe/London"},
+		{"Brooklyn", 40.68, -73.94, "America/New_York"},
+		{"San Francisco", 37.77, -122.42, "America/Los_Angeles"},
+		{"Tokyo", 35.68, 139.69, "Asia/Tokyo"},
+		{"Sydney", -33.87, 151.21, "Australia/Sydney"},
+		{"Honolulu", 21.31, -157.86, "Pacific/Honolulu"},
+		{"across the date line", -36.85, -179.9, "Pacific/Auckland"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zoneForCoordinates(tt.lat, tt.lon); got != tt.want {
+				t.Errorf("zoneForCoordinates(%v, %v) = %q, want %q", tt.lat, tt.lon, got, tt.want)
+			}
+		})
+	}
+}
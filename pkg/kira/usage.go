@@ -0,0 +1,263 @@
+package kira
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitea.karlbreuer.com/karl1b/kira/pkg/settings"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// usageKind separates the token accounting of the different LLM calls
+type usageKind string
+
+const (
+	usageTalk   usageKind = "talk"   // conversation replies
+	usageMemory usageKind = "memory" // memory extraction by the helper
+)
+
+const globalUsageFile = "usage.json"
+
+// modelPrice is the price in USD per million tokens
+type modelPrice struct {
+	Input  float64
+	Cached float64 // input tokens read from a prompt cache
+	Output float64
+}
+
+// modelPrices is used to calculate the spending for the global cap
+var modelPrices = map[string]modelPrice{
+	talkModelName:   {Input: 0.30, Cached: 0.075, Output: 2.50},
+	helperModelName: {Input: 0.10, Cached: 0.025, Output: 0.40},
+}
+
+// TokenUsage counts prompt and response tokens
+type TokenUsage struct {
+	PromptTokens   int64 `json:"prompt_tokens"`
+	ResponseTokens int64 `json:"response_tokens"`
+	CachedTokens   int64 `json:"cached_tokens,omitempty"` // part of PromptTokens that came from a prompt cache
+}
+
+// Total returns prompt plus response tokens
+func (u TokenUsage) Total() int64 {
+	return u.PromptTokens + u.ResponseTokens
+}
+
+// UsagePeriod is the usage of one day ("2025-01-15") or month ("2025-01")
+type UsagePeriod struct {
+	Period string     `json:"period"`
+	Talk   TokenUsage `json:"talk"`
+	Memory TokenUsage `json:"memory"`
+}
+
+// get returns the usage of kind
+func (p *UsagePeriod) get(kind usageKind) *TokenUsage {
+	if kind == usageMemory {
+		return &p.Memory
+	}
+	return &p.Talk
+}
+
+// roll resets the period if it is not the current one
+func (p *UsagePeriod) roll(current string) {
+	if p.Period != current {
+		*p = UsagePeriod{Period: current}
+	}
+}
+
+// ChatUsage is the token usage of a single chat
+type ChatUsage struct {
+	Day   UsagePeriod `json:"day"`
+	Month UsagePeriod `json:"month"`
+}
+
+// GlobalUsage is the token usage and spending of the whole deployment
+type GlobalUsage struct {
+	Month    UsagePeriod `json:"month"`
+	SpendUSD float64     `json:"spend_usd"`
+}
+
+// roll resets usage and spending if the month is not the current one
+func (g *GlobalUsage) roll(month string) {
+	if g.Month.Period != month {
+		*g = GlobalUsage{Month: UsagePeriod{Period: month}}
+	}
+}
+
+// checkTokenBudget returns an error if the chat or the deployment has no tokens left for kind.
+// The budgets of a chat reset in the chat's time zone, the global cap in the server's.
+func (k *KiraBot) checkTokenBudget(chatID int64, kind usageKind) *limitError {
+	serverNow := time.Now()
+
+	k.usageMu.Lock()
+	global := k.globalUsage
+	k.usageMu.Unlock()
+	global.roll(serverNow.Format("2006-01"))
+
+	if spendCap := settings.Settings.GlobalMonthlySpendCap; spendCap > 0 && global.SpendUSD >= spendCap {
+		return &limitError{reason: fmt.Sprintf("global monthly spending cap of $%.2f", spendCap), resetAt: nextMonth(serverNow)}
+	}
+
+	k.mu.Lock()
+	chat := k.chats[chatID]
+	k.mu.Unlock()
+	now := k.nowFor(chat)
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	usage := chat.Usage
+	usage.Day.roll(day)
+	usage.Month.roll(month)
+
+	if kind == usageMemory {
+		if budget := settings.Settings.MemoryDailyTokenBudget; budget > 0 && usage.Day.Memory.Total() >= int64(budget) {
+			return &limitError{reason: fmt.Sprintf("daily memory token budget of %d", budget), resetAt: nextMidnight(now)}
+		}
+		return nil
+	}
+
+	plan := k.planForChat(chat)
+	if budget := plan.DailyTokenBudget; budget > 0 && usage.Day.Talk.Total() >= int64(budget) {
+		return &limitError{reason: fmt.Sprintf("daily token budget of %d", budget), resetAt: nextMidnight(now)}
+	}
+	if budget := plan.MonthlyTokenBudget; budget > 0 && usage.Month.Talk.Total() >= int64(budget) {
+		return &limitError{reason: fmt.Sprintf("monthly token budget of %d", budget), resetAt: nextMonth(now)}
+	}
+
+	return nil
+}
+
+// recordUsage books the token usage reported by the provider on the chat and the deployment
+func (k *KiraBot) recordUsage(chatID int64, kind usageKind, model string, meta *genai.UsageMetadata) {
+	if meta == nil {
+		log.Printf("No usage metadata for chat %d (%s)", chatID, kind)
+		return
+	}
+
+	used := TokenUsage{
+		PromptTokens:   int64(meta.PromptTokenCount),
+		ResponseTokens: int64(meta.CandidatesTokenCount),
+		CachedTokens:   int64(meta.CachedContentTokenCount),
+	}
+
+	k.mu.Lock()
+	chat, exists := k.chats[chatID]
+	if !exists {
+		chat = CompleteChat{
+			ChatId: chatID,
+			Chats:  make(map[int]ChatMessage),
+		}
+	}
+	now := k.nowFor(chat)
+	chat.Usage.Day.roll(now.Format("2006-01-02"))
+	chat.Usage.Month.roll(now.Format("2006-01"))
+	addUsage(chat.Usage.Day.get(kind), used)
+	addUsage(chat.Usage.Month.get(kind), used)
+	k.chats[chatID] = chat
+	chatUsage := chat.Usage
+	k.mu.Unlock()
+
+	price := modelPrices[model]
+	spend := (float64(used.PromptTokens-used.CachedTokens)*price.Input +
+		float64(used.CachedTokens)*price.Cached +
+		float64(used.ResponseTokens)*price.Output) / 1_000_000
+
+	k.usageMu.Lock()
+	k.globalUsage.roll(time.Now().Format("2006-01"))
+	addUsage(k.globalUsage.Month.get(kind), used)
+	k.globalUsage.SpendUSD += spend
+	if err := saveGlobalUsage(k.globalUsage); err != nil {
+		log.Printf("Error saving global usage: %v", err)
+	}
+	k.usageMu.Unlock()
+
+	log.Printf("Token usage for chat %d (%s): %d prompt, %d response, today %d talk / %d memory",
+		chatID, kind, used.PromptTokens, used.ResponseTokens, chatUsage.Day.Talk.Total(), chatUsage.Day.Memory.Total())
+
+	if err := saveChatUsage(chatID, chatUsage); err != nil {
+		log.Printf("Error saving usage for chat %d: %v", chatID, err)
+	}
+}
+
+func addUsage(dst *TokenUsage, used TokenUsage) {
+	dst.PromptTokens += used.PromptTokens
+	dst.ResponseTokens += used.ResponseTokens
+	dst.CachedTokens += used.CachedTokens
+}
+
+// loadChatUsage loads the token usage of a chat from usage.json
+func (k *KiraBot) loadChatUsage(chatID int64, filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read usage file: %v", err)
+	}
+
+	var usage ChatUsage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return fmt.Errorf("failed to decode usage file: %v", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	chat, exists := k.chats[chatID]
+	if !exists {
+		chat = CompleteChat{
+			ChatId: chatID,
+			Chats:  make(map[int]ChatMessage),
+		}
+	}
+	chat.Usage = usage
+	k.chats[chatID] = chat
+	return nil
+}
+
+// saveChatUsage saves the token usage of a chat to usage.json
+func saveChatUsage(chatID int64, usage ChatUsage) error {
+	chatDir := chatDirPath(chatID)
+	if err := os.MkdirAll(chatDir, 0700); err != nil {
+		return fmt.Errorf("failed to create chat directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(chatDir, "usage.json"), data, 0600); err != nil {
+		return fmt.Errorf("failed to write usage file: %v", err)
+	}
+	return nil
+}
+
+// loadGlobalUsage loads the usage of the deployment, starting fresh if there is none
+func loadGlobalUsage() GlobalUsage {
+	var usage GlobalUsage
+
+	data, err := os.ReadFile(globalUsageFile)
+	if err != nil {
+		log.Printf("Could not read global usage (starting fresh): %v", err)
+		return usage
+	}
+	if err := json.Unmarshal(data, &usage); err != nil {
+		log.Printf("Could not parse global usage (starting fresh): %v", err)
+		return GlobalUsage{}
+	}
+
+	log.Printf("Global usage for %s: $%.2f", usage.Month.Period, usage.SpendUSD)
+	return usage
+}
+
+// saveGlobalUsage saves the usage of the deployment
+func saveGlobalUsage(usage GlobalUsage) error {
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal global usage: %v", err)
+	}
+	return os.WriteFile(globalUsageFile, data, 0600)
+}
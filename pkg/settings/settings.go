@@ -19,4 +19,54 @@ func init() {
 type Go4lageSettings struct {
 	TelegramToken string `env:"TELEGRAMTOKEN"`
 	LlmKey        string `env:"LLMKEY"`
+
+	// Encryption at rest, base64 encoded 32 byte key. Leave both empty to store plaintext.
+	EncryptionKey     string `env:"ENCRYPTIONKEY,optional"`
+	EncryptionKeyFile string `env:"ENCRYPTIONKEYFILE,optional"`
+
+	// Plans file with per-user limits, reloaded when it changes
+	PlansFile string `env:"PLANSFILE" default:"plans.json"`
+
+	// Moderation pipeline config and the chat that receives moderation alerts
+	ModerationFile string `env:"MODERATIONFILE" default:"moderation.json"`
+	AdminChatID    int64  `env:"ADMINCHATID,optional"`
+
+	// Crisis detection rules, hotline messages and optional classifier
+	CrisisFile string `env:"CRISISFILE" default:"crisis.json"`
+
+	// Directory with one subdirectory per language and one YAML file per persona in it,
+	// and the persona of chats without an assignment
+	PersonaDir     string `env:"PERSONADIR" default:"personas"`
+	DefaultPersona string `env:"DEFAULTPERSONA" default:"kira"`
+
+	// Directory with one language pack per language and the language of chats without an assignment
+	LanguageDir string `env:"LANGUAGEDIR" default:"lang"`
+	Language    string `env:"LANGUAGE" default:"de"`
+
+	// Time zone of chats that have none set, e.g. Europe/Berlin. Empty is the server's zone.
+	Timezone string `env:"TIMEZONE,optional"`
+
+	// Switch a chat to the language the user writes in, unless plans.json assigns one
+	DetectLanguage bool `env:"DETECTLANGUAGE" default:"true"`
+
+	// Prompt variant experiments, see experiments.example.json
+	ExperimentsFile string `env:"EXPERIMENTSFILE" default:"experiments.json"`
+
+	// Persona rules that generated replies are checked against before sending
+	GuardrailsFile string `env:"GUARDRAILSFILE" default:"guardrails.json"`
+
+	// Messages in a row are answered together: the reply waits until the user
+	// wrote nothing for BURSTQUIETSECONDS, but at most BURSTMAXWAITSECONDS after
+	// the first message of the burst (capped to 5 minutes). 0 answers right away.
+	BurstQuietSeconds   int `env:"BURSTQUIETSECONDS" default:"15"`
+	BurstMaxWaitSeconds int `env:"BURSTMAXWAITSECONDS" default:"60"`
+
+	// Cache the system prompt and memory with Gemini's context caching
+	PromptCaching bool `env:"PROMPTCACHING" default:"true"`
+
+	// Token budgets, 0 disables a budget. Plans can override the conversation budgets.
+	DailyTokenBudget       int     `env:"DAILYTOKENBUDGET" default:"200000"`       // conversation tokens per chat and day
+	MonthlyTokenBudget     int     `env:"MONTHLYTOKENBUDGET" default:"3000000"`    // conversation tokens per chat and month
+	MemoryDailyTokenBudget int     `env:"MEMORYDAILYTOKENBUDGET" default:"100000"` // memory extraction tokens per chat and day
+	GlobalMonthlySpendCap  float64 `env:"GLOBALMONTHLYSPENDCAP,optional"`          // USD for the whole deployment
 }